@@ -0,0 +1,135 @@
+// genflags regenerates koi/kubectl_flags_gen.go from the output of `kubectl options`
+//
+// Usage (from the koi directory, see the go:generate line in kubectl_flags.go):
+//
+//	go run ../hack/genflags -o kubectl_flags_gen.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type kubectlFlag struct {
+	long       string
+	short      string
+	takesValue bool
+}
+
+// Matches option lines such as "-n, --namespace=VALUE:" and "--insecure-skip-tls-verify=false:"
+var optionLineRegex = regexp.MustCompile(`^\s+(?:-([a-zA-Z]), )?--([a-z0-9-]+)=(.*):$`)
+
+func main() {
+	kubectl := flag.String("kubectl", "kubectl", "The kubectl binary to read options from")
+	input := flag.String("input", "", "Read `kubectl options` output from this file instead of running kubectl")
+	output := flag.String("o", "kubectl_flags_gen.go", "The file to write")
+	flag.Parse()
+
+	var options []byte
+	var err error
+	source := *kubectl + " options"
+	if *input != "" {
+		options, err = os.ReadFile(*input)
+		source = *input
+	} else {
+		options, err = exec.Command(*kubectl, "options").Output()
+		if version := kubectlVersion(*kubectl); version != "" {
+			source = fmt.Sprintf("%s options` of kubectl `%s", *kubectl, version)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read kubectl options: %v\n", err)
+		os.Exit(1)
+	}
+
+	flags, err := parseKubectlOptions(bytes.NewReader(options))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse kubectl options: %v\n", err)
+		os.Exit(1)
+	}
+
+	src, err := renderFlags(source, flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to render flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = os.WriteFile(*output, src, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+}
+
+// kubectlVersion returns the client version of kubectl, eg: v1.33.0, or nothing if it cannot be read
+func kubectlVersion(kubectl string) string {
+	out, err := exec.Command(kubectl, "version", "--client", "--output=json").Output()
+	if err != nil {
+		return ""
+	}
+	version := struct {
+		ClientVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"clientVersion"`
+	}{}
+	if json.Unmarshal(out, &version) != nil {
+		return ""
+	}
+	return version.ClientVersion.GitVersion
+}
+
+// parseKubectlOptions reads the flags from `kubectl options`
+// A flag is boolean when its default value is true or false, every other flag takes a value
+func parseKubectlOptions(r io.Reader) ([]kubectlFlag, error) {
+	flags := []kubectlFlag{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := optionLineRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		defaultValue := match[3]
+		flags = append(flags, kubectlFlag{
+			short:      match[1],
+			long:       match[2],
+			takesValue: defaultValue != "true" && defaultValue != "false",
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(flags) == 0 {
+		return nil, fmt.Errorf("no flags found")
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].long < flags[j].long })
+	return flags, nil
+}
+
+func renderFlags(source string, flags []kubectlFlag) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by hack/genflags from `%s`; DO NOT EDIT.\n\n", source)
+	b.WriteString("package koi\n\n")
+	b.WriteString("var kubectlGlobalFlags = []kubectlFlag{\n")
+	for _, f := range flags {
+		fmt.Fprintf(&b, "\t{long: %q", f.long)
+		if f.short != "" {
+			fmt.Fprintf(&b, ", short: %q", f.short)
+		}
+		if f.takesValue {
+			b.WriteString(", takesValue: true")
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("}\n")
+	return format.Source([]byte(b.String()))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_parseKubectlOptions(t *testing.T) {
+	f, err := os.Open("testdata/kubectl-options.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	flags, err := parseKubectlOptions(f)
+	if err != nil {
+		t.Fatalf("parseKubectlOptions() error = %v", err)
+	}
+
+	byName := map[string]kubectlFlag{}
+	for _, flag := range flags {
+		byName[flag.long] = flag
+	}
+
+	tests := []struct {
+		name string
+		want kubectlFlag
+	}{
+		{name: "namespace", want: kubectlFlag{long: "namespace", short: "n", takesValue: true}},
+		{name: "insecure-skip-tls-verify", want: kubectlFlag{long: "insecure-skip-tls-verify"}},
+		{name: "as-group", want: kubectlFlag{long: "as-group", takesValue: true}},
+		{name: "vmodule", want: kubectlFlag{long: "vmodule", takesValue: true}},
+		{name: "v", want: kubectlFlag{long: "v", short: "v", takesValue: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := byName[tt.name]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseKubectlOptions() %s got: %+v, want: %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
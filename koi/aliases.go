@@ -0,0 +1,141 @@
+package koi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var aliasPositionalRegex = regexp.MustCompile(`\$(\d+)`)
+
+// ExpandAliases replaces the command in args with its alias from the config
+// Positional references ($1, $2, ...) in the alias are replaced by the args after the alias name
+// Args which are not referenced are appended after the expansion
+// Aliases may refer to other aliases, but each alias is only expanded once
+func ExpandAliases(settings Settings, args []string) ([]string, error) {
+	expanded := map[string]bool{}
+	shorthands := shorthandReplacements(settings)
+
+	for {
+		i := aliasIndex(args, shorthands)
+		if i < 0 {
+			return args, nil
+		}
+		name := args[i]
+		alias, ok := settings.Aliases[name]
+		if !ok || expanded[name] {
+			return args, nil
+		}
+		expanded[name] = true
+
+		words, err := splitWords(alias)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse alias %q", name)
+		}
+
+		rest := args[i+1:]
+		used := map[int]bool{}
+		for w, word := range words {
+			var substituteErr error
+			words[w] = aliasPositionalRegex.ReplaceAllStringFunc(word, func(ref string) string {
+				n, _ := strconv.Atoi(ref[1:])
+				if n < 1 || n > len(rest) || rest[n-1] == "--" {
+					substituteErr = fmt.Errorf("alias %q needs at least %d arguments: %s", name, n, alias)
+					return ref
+				}
+				used[n-1] = true
+				return rest[n-1]
+			})
+			if substituteErr != nil {
+				return nil, substituteErr
+			}
+		}
+
+		newArgs := make([]string, 0, len(args)+len(words))
+		newArgs = append(newArgs, args[:i]...)
+		newArgs = append(newArgs, words...)
+		for r, arg := range rest {
+			if !used[r] {
+				newArgs = append(newArgs, arg)
+			}
+		}
+		log.Debugf("Expanded alias %q to %q", name, newArgs)
+		args = newArgs
+	}
+}
+
+// aliasIndex is getCommandIndex which also skips the values of koi's shorthand flags, eg: -N foo
+// Aliases are expanded before the shorthands are replaced, so kubectl's flag table does not know them yet
+func aliasIndex(args []string, shorthands map[string]string) int {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if longform, ok := shorthands[arg]; ok {
+			if flagConsumesNextArg(longform) {
+				i++
+			}
+			continue
+		}
+		if flagConsumesNextArg(arg) {
+			i++
+			continue
+		}
+
+		if !strings.HasPrefix(arg, "-") {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitWords splits s into words the way a shell would, honouring quotes and backslashes
+func splitWords(s string) ([]string, error) {
+	words := []string{}
+	var current strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,132 @@
+package koi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandAliases(t *testing.T) {
+	aliases := map[string]string{
+		"gp":    "get pods -o wide --sort-by=.status.startTime",
+		"crash": "get pods --field-selector='status.phase!=Running'",
+		"logs1": "logs $1 -c $2 --tail=10",
+		"gpw":   "gp --watch",
+		"loop":  "loop -n koi",
+	}
+	settings := Settings{Aliases: aliases, Shorthands: map[string]string{"-N": "--namespace"}}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Alias should be expanded",
+			args: []string{"gp"},
+			want: []string{"get", "pods", "-o", "wide", "--sort-by=.status.startTime"},
+		},
+		{
+			name: "Flags before the alias and args after it should be kept",
+			args: []string{"-n", "koi", "gp", "-l", "app=koi"},
+			want: []string{"-n", "koi", "get", "pods", "-o", "wide", "--sort-by=.status.startTime", "-l", "app=koi"},
+		},
+		{
+			name: "The value of a shorthand before the alias should not be taken as the alias",
+			args: []string{"-N", "koi", "gp"},
+			want: []string{"-N", "koi", "get", "pods", "-o", "wide", "--sort-by=.status.startTime"},
+		},
+		{
+			name: "The value of -x before the alias should not be taken as the alias",
+			args: []string{"-x", "prod", "gp"},
+			want: []string{"-x", "prod", "get", "pods", "-o", "wide", "--sort-by=.status.startTime"},
+		},
+		{
+			name: "Quotes in the alias should be removed",
+			args: []string{"crash"},
+			want: []string{"get", "pods", "--field-selector=status.phase!=Running"},
+		},
+		{
+			name: "Positional args should be substituted and not repeated",
+			args: []string{"logs1", "my-pod", "app", "-f"},
+			want: []string{"logs", "my-pod", "-c", "app", "--tail=10", "-f"},
+		},
+		{
+			name:    "Missing positional args should be an error",
+			args:    []string{"logs1", "my-pod"},
+			wantErr: true,
+		},
+		{
+			name: "Aliases can refer to other aliases",
+			args: []string{"gpw"},
+			want: []string{"get", "pods", "-o", "wide", "--sort-by=.status.startTime", "--watch"},
+		},
+		{
+			name: "An alias referring to itself should only be expanded once",
+			args: []string{"loop"},
+			want: []string{"loop", "-n", "koi"},
+		},
+		{
+			name: "Commands which are not aliases should be left alone",
+			args: []string{"get", "gp"},
+			want: []string{"get", "gp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandAliases(settings, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandAliases() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandAliases() got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitWords(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "Words are split on whitespace",
+			input: "get  pods\t-o wide",
+			want:  []string{"get", "pods", "-o", "wide"},
+		},
+		{
+			name:  "Quotes keep spaces",
+			input: `get pods -l "app in (a, b)" --template='{{ .x }}'`,
+			want:  []string{"get", "pods", "-l", "app in (a, b)", "--template={{ .x }}"},
+		},
+		{
+			name:  "Backslashes escape",
+			input: `a\ b "c\"d"`,
+			want:  []string{"a b", `c"d`},
+		},
+		{
+			name:  "Empty quotes are a word",
+			input: `a ""`,
+			want:  []string{"a", ""},
+		},
+		{
+			name:    "Unterminated quotes are an error",
+			input:   `get "pods`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitWords(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitWords() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitWords() got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
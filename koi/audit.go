@@ -0,0 +1,341 @@
+package koi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/rodaine/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// AuditConfig says where koi records the commands it runs
+type AuditConfig struct {
+	Disabled bool `yaml:"disabled,omitempty"`
+	// File is the JSONL file records are appended to, KOI_AUDIT_FILE
+	File string `yaml:"file,omitempty"`
+	// Syslog forwards records to syslog: "local" or an address such as udp://logs:514
+	Syslog string `yaml:"syslog,omitempty"`
+	// URL forwards records to an HTTP endpoint, one JSON POST per record
+	URL string `yaml:"url,omitempty"`
+}
+
+// AuditRecord is one line of the audit log
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Host      string    `json:"host,omitempty"`
+	Context   string    `json:"context,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	// Command is the koi command which ran, empty when the args went to kubectl
+	Command string `json:"command,omitempty"`
+	// Args are the final args, after aliases and koi's tweaks
+	Args     []string `json:"args"`
+	ExitCode int      `json:"exitCode"`
+	Duration float64  `json:"durationSeconds"`
+	Error    string   `json:"error,omitempty"`
+	// Reason is the reason given for a koi shell
+	Reason string `json:"reason,omitempty"`
+}
+
+// secretAuditFlags are the flags whose values are credentials, they are never written to the audit log
+// Who koi ran as, eg: --as, is what the log is for, so it is kept
+var secretAuditFlags = []string{"--token", "--password", "--client-key"}
+
+// redactedValue replaces the value of a secret flag in the audit log
+const redactedValue = "REDACTED"
+
+// httpAuditTimeout keeps a slow audit endpoint from holding up the terminal
+const httpAuditTimeout = 3 * time.Second
+
+func defaultAuditFile() string {
+	return filepath.Join(stateDir(), "audit.jsonl")
+}
+
+// NewAuditRecord starts the record for a command, the context and namespace are resolved the way kubectl would
+func NewAuditRecord(settings Settings, commandName string, args []string) *AuditRecord {
+	record := &AuditRecord{
+		Time:    time.Now(),
+		User:    currentUser(),
+		Command: commandName,
+		Args:    redactAuditArgs(args),
+	}
+	record.Host, _ = os.Hostname()
+
+	conn := kubeConnectionFromArgs(args)
+	record.Context, _ = kubeconfigCurrentContext(conn)
+	record.Namespace = extractValueArgumentFromArgs(args, "--namespace", "-n")
+	if record.Namespace == "" {
+		record.Namespace, _ = kubeconfigNamespace(conn)
+	}
+	return record
+}
+
+// redactAuditArgs returns a copy of args with the values of secret flags replaced, both as --flag=value and --flag value
+func redactAuditArgs(args []string) []string {
+	ret := make([]string, 0, len(args))
+	redactNext := false
+	for _, arg := range args {
+		if redactNext {
+			ret = append(ret, redactedValue)
+			redactNext = false
+			continue
+		}
+		name, _, hasValue := strings.Cut(arg, "=")
+		if stringArrayContains(secretAuditFlags, name) {
+			if hasValue {
+				arg = name + "=" + redactedValue
+			} else {
+				redactNext = true
+			}
+		}
+		ret = append(ret, arg)
+	}
+	return ret
+}
+
+// Finish records how the command ended, it does nothing on a nil record
+func (r *AuditRecord) Finish(exitCode int, runError error) {
+	if r == nil {
+		return
+	}
+	r.ExitCode = exitCode
+	r.Duration = time.Since(r.Time).Round(time.Millisecond).Seconds()
+	if runError != nil {
+		r.Error = runError.Error()
+		if exitCode == 0 {
+			r.ExitCode = 1
+		}
+	}
+}
+
+// WriteAuditRecord appends the record to the audit file and forwards it to the configured sinks
+// Failing to audit is logged, it never fails the command which already ran
+func WriteAuditRecord(config AuditConfig, record *AuditRecord) {
+	if config.Disabled || record == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Warnf("Failed to encode audit record: %v", err)
+		return
+	}
+
+	path := coalesceString(config.File, defaultAuditFile())
+	if err := appendAuditLine(path, line); err != nil {
+		log.Warn(err)
+	}
+	if config.Syslog != "" {
+		if err := sendAuditToSyslog(config.Syslog, line); err != nil {
+			log.Warn(err)
+		}
+	}
+	if config.URL != "" {
+		if err := sendAuditToURL(config.URL, line); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+func appendAuditLine(path string, line []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create audit dir for %s", path)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open audit file %s", path)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return errors.Wrapf(err, "failed to write audit file %s", path)
+}
+
+func sendAuditToSyslog(address string, line []byte) error {
+	var writer *syslog.Writer
+	var err error
+	if address == "local" {
+		writer, err = syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "koi")
+	} else {
+		u, parseErr := url.Parse(address)
+		if parseErr != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("audit syslog %q must be \"local\" or an address such as udp://host:514", address)
+		}
+		writer, err = syslog.Dial(u.Scheme, u.Host, syslog.LOG_INFO|syslog.LOG_AUTH, "koi")
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to syslog %s", address)
+	}
+	defer writer.Close()
+	return errors.Wrap(writer.Info(string(line)), "failed to write audit record to syslog")
+}
+
+func sendAuditToURL(address string, line []byte) error {
+	client := http.Client{Timeout: httpAuditTimeout}
+	resp, err := client.Post(address, "application/json", bytes.NewReader(line))
+	if err != nil {
+		return errors.Wrapf(err, "failed to send audit record to %s", address)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sending audit record to %s: %s", address, resp.Status)
+	}
+	return nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+type AuditOptions struct {
+	since    time.Duration
+	context  string
+	command  string
+	user     string
+	failed   bool
+	limit    int
+	output   string
+	colorize bool
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "audit",
+		Summary: "Search the log of commands koi has run",
+		Usage:   "koi audit [flags] [TEXT...]",
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			opts := AuditOptions{}
+			f.DurationVar(&opts.since, "since", 0, "Only show commands run within this duration, eg: 24h")
+			f.StringVarP(&opts.context, "context", "x", "", "Only show commands run against this context")
+			f.StringVar(&opts.command, "command", "", "Only show this koi or kubectl command, eg: delete")
+			f.StringVar(&opts.user, "user", "", "Only show commands run by this user")
+			f.BoolVar(&opts.failed, "failed", false, "Only show commands which failed")
+			f.IntVar(&opts.limit, "limit", 50, "Show at most this many of the latest commands, 0 for all")
+			f.StringVarP(&opts.output, "output", "o", "table", "Output format: table or json")
+			f.BoolVar(&opts.colorize, "color", WritingToTerminal(), "Configure color output")
+			return func(inv Invocation) (int, error) {
+				return AuditCommand(inv.Settings, opts, inv.Args)
+			}
+		},
+		SkipAudit: true,
+	})
+}
+
+// AuditCommand prints the audit records which match the options and contain all of the search terms
+func AuditCommand(settings Settings, opts AuditOptions, terms []string) (exitCode int, runError error) {
+	path := coalesceString(settings.Audit.File, defaultAuditFile())
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 1, errors.Wrapf(err, "failed to open audit file %s", path)
+	}
+	defer f.Close()
+
+	records, err := searchAuditRecords(f, opts, terms, time.Now())
+	if err != nil {
+		return 1, errors.Wrapf(err, "failed to read audit file %s", path)
+	}
+
+	switch opts.output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			encoder.Encode(record)
+		}
+	case "table", "":
+		printAuditTable(records, opts.colorize)
+	default:
+		return 1, fmt.Errorf("unknown output format %q, use table or json", opts.output)
+	}
+	return 0, nil
+}
+
+// searchAuditRecords returns the latest records from r which match, oldest first
+func searchAuditRecords(r io.Reader, opts AuditOptions, terms []string, now time.Time) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		record := AuditRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			log.Debugf("Skipping bad audit line: %v", err)
+			continue
+		}
+		if opts.since > 0 && record.Time.Before(now.Add(-opts.since)) {
+			continue
+		}
+		if opts.context != "" && record.Context != opts.context {
+			continue
+		}
+		if opts.user != "" && record.User != opts.user {
+			continue
+		}
+		if opts.failed && record.ExitCode == 0 {
+			continue
+		}
+		if opts.command != "" && record.Command != opts.command && GetCommand(record.Args) != opts.command {
+			continue
+		}
+		if !containsAllTerms(string(line), terms) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if opts.limit > 0 && len(records) > opts.limit {
+		records = records[len(records)-opts.limit:]
+	}
+	return records, scanner.Err()
+}
+
+func containsAllTerms(s string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(s, term) {
+			return false
+		}
+	}
+	return true
+}
+
+func printAuditTable(records []AuditRecord, colorize bool) {
+	tbl := table.New("Time", "User", "Context", "Namespace", "Exit", "Duration", "Command")
+	for _, record := range records {
+		exit := fmt.Sprint(record.ExitCode)
+		if colorize && record.ExitCode != 0 {
+			exit = color.RedString("%d", record.ExitCode)
+		}
+		command := strings.Join(record.Args, " ")
+		if record.Command != "" {
+			command = strings.TrimSpace(record.Command + " " + command)
+		}
+		if record.Reason != "" {
+			command += fmt.Sprintf(" (reason: %s)", record.Reason)
+		}
+		duration := time.Duration(record.Duration * float64(time.Second)).String()
+		tbl.AddRow(record.Time.Local().Format(time.DateTime), record.User, record.Context, record.Namespace, exit, duration, command)
+	}
+	if colorize {
+		tbl.WithHeaderFormatter(color.New(color.FgHiWhite, color.Underline).SprintfFunc())
+	}
+	tbl.Print()
+}
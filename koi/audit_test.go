@@ -0,0 +1,133 @@
+package koi
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAuditLog = `{"time":"2024-05-01T10:00:00Z","user":"alice","context":"prod","namespace":"payments","args":["delete","pod","api-1"],"exitCode":0,"durationSeconds":1.2}
+{"time":"2024-05-01T11:00:00Z","user":"bob","context":"kind-kind","namespace":"default","args":["get","pods"],"exitCode":0,"durationSeconds":0.3}
+not json
+{"time":"2024-05-01T11:30:00Z","user":"alice","context":"prod","namespace":"payments","command":"shell","args":["--context","prod"],"exitCode":1,"durationSeconds":30,"reason":"INC-42"}
+{"time":"2024-05-01T11:50:00Z","user":"alice","context":"prod","namespace":"payments","args":["rollout","restart","deploy/api"],"exitCode":0,"durationSeconds":0.5}
+`
+
+func Test_searchAuditRecords(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		opts  AuditOptions
+		terms []string
+		want  []string
+	}{
+		{
+			name: "No filters should return every record",
+			want: []string{"delete", "get", "shell", "rollout"},
+		},
+		{
+			name: "Since should drop older records",
+			opts: AuditOptions{since: 45 * time.Minute},
+			want: []string{"shell", "rollout"},
+		},
+		{
+			name: "Context and user should both have to match",
+			opts: AuditOptions{context: "prod", user: "alice"},
+			want: []string{"delete", "shell", "rollout"},
+		},
+		{
+			name: "Failed should only return failed commands",
+			opts: AuditOptions{failed: true},
+			want: []string{"shell"},
+		},
+		{
+			name: "Command should match kubectl and koi commands",
+			opts: AuditOptions{command: "delete"},
+			want: []string{"delete"},
+		},
+		{
+			name:  "Terms should all have to be in the record",
+			terms: []string{"INC-42", "alice"},
+			want:  []string{"shell"},
+		},
+		{
+			name: "Limit should keep the latest records",
+			opts: AuditOptions{limit: 2},
+			want: []string{"shell", "rollout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := searchAuditRecords(strings.NewReader(testAuditLog), tt.opts, tt.terms, now)
+			if err != nil {
+				t.Fatalf("searchAuditRecords() error = %v", err)
+			}
+			got := []string{}
+			for _, record := range records {
+				got = append(got, coalesceString(record.Command, GetCommand(record.Args)))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("searchAuditRecords() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_redactAuditArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "Values after secret flags should be redacted",
+			args: []string{"get", "pods", "--token", "abc", "--client-key", "/keys/me.pem", "-n", "web"},
+			want: []string{"get", "pods", "--token", redactedValue, "--client-key", redactedValue, "-n", "web"},
+		},
+		{
+			name: "Values joined with = should be redacted",
+			args: []string{"--password=hunter2", "--client-key=/keys/me.pem", "get", "pods"},
+			want: []string{"--password=" + redactedValue, "--client-key=" + redactedValue, "get", "pods"},
+		},
+		{
+			name: "Who the command ran as should be kept",
+			args: []string{"get", "pods", "--as", "admin", "--as-group", "admins", "--username=alice"},
+			want: []string{"get", "pods", "--as", "admin", "--as-group", "admins", "--username=alice"},
+		},
+		{
+			name: "Flags which only start like a secret flag should be kept",
+			args: []string{"get", "pods", "--password-file=/p", "--tokens=1"},
+			want: []string{"get", "pods", "--password-file=/p", "--tokens=1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactAuditArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redactAuditArgs() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAuditRecordRedactsTokens(t *testing.T) {
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "config"))
+	args := []string{"get", "pods", "--token", "s3cr3t-a", "--token=s3cr3t-b", "--as", "admin"}
+	record := NewAuditRecord(Settings{}, "", args)
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if strings.Contains(string(line), "s3cr3t") {
+		t.Errorf("NewAuditRecord() should not record the token, got: %s", line)
+	}
+	if !strings.Contains(string(line), `"--as","admin"`) {
+		t.Errorf("NewAuditRecord() should record who the command ran as, got: %s", line)
+	}
+	if args[3] != "s3cr3t-a" {
+		t.Errorf("NewAuditRecord() should not change the args it is given, got: %v", args)
+	}
+}
@@ -0,0 +1,404 @@
+package koi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// Shell completion uses the same protocol as kubectl (cobra): one completion per line,
+// optionally followed by a tab and a description, then a line with `:DIRECTIVE`
+const (
+	completionDirectiveError      = 1
+	completionDirectiveNoSpace    = 2
+	completionDirectiveNoFileComp = 4
+)
+
+const completionTimeout = 5 * time.Second
+
+// koiCompletionFlags are the flags koi adds to every kubectl command
+var koiCompletionFlags = []string{
+	"-x\tThe kubeconfig context to use",
+	"--jq\tFilter the output with a jq expression",
+	"--yq\tFilter the output with a jq expression and print it as yaml",
+	"--yes\tSkip the confirmation for protected contexts",
+	"--contexts\tRun the command in every context matching a comma separated list of globs",
+	"--koi-help\tShow help for koi",
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:               "completion",
+		Summary:            "Print a shell completion script for koi, kshell and kcontainers",
+		Usage:              "koi completion bash|zsh|fish",
+		DisableFlagParsing: true,
+		SkipAudit:          true,
+		SkipFilter:         true,
+		Complete: func(settings Settings, args []string, toComplete string) []string {
+			return []string{"bash", "zsh", "fish"}
+		},
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			return func(inv Invocation) (int, error) {
+				return CompletionCommand(inv.Args)
+			}
+		},
+	})
+
+	RegisterCommand(&Command{
+		Name:               "__complete",
+		Summary:            "Print completions for the given args, used by the completion scripts",
+		Usage:              "koi __complete ARGS... TO_COMPLETE",
+		DisableFlagParsing: true,
+		Hidden:             true,
+		SkipAudit:          true,
+		SkipFilter:         true,
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			return func(inv Invocation) (int, error) {
+				// Use the args as they were typed, so the word being completed stays last
+				args := inv.Args
+				for i, a := range inv.RawArgs {
+					if a == "__complete" {
+						args = inv.RawArgs[i+1:]
+						break
+					}
+				}
+				completions, directive := completeArgs(inv.Settings, args)
+				for _, c := range completions {
+					fmt.Println(c)
+				}
+				fmt.Printf(":%d\n", directive)
+				return 0, nil
+			}
+		},
+	})
+}
+
+func CompletionCommand(args []string) (exitCode int, runError error) {
+	if len(args) != 1 {
+		return 1, fmt.Errorf("usage: koi completion bash|zsh|fish")
+	}
+	switch args[0] {
+	case "bash":
+		io.WriteString(os.Stdout, bashCompletion)
+	case "zsh":
+		io.WriteString(os.Stdout, zshCompletion)
+	case "fish":
+		io.WriteString(os.Stdout, fishCompletion)
+	default:
+		return 1, fmt.Errorf("unsupported shell %q, use bash, zsh or fish", args[0])
+	}
+	return 0, nil
+}
+
+// completeArgs returns the completions for the last arg
+func completeArgs(settings Settings, args []string) ([]string, int) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	toComplete := args[len(args)-1]
+	preceding, err := ExpandAliases(settings, args[:len(args)-1])
+	if err != nil {
+		preceding = args[:len(args)-1]
+	}
+
+	cmd, cmdArgs := LookupCommand("koi", preceding)
+	if cmd == nil {
+		return completeKubectl(settings, preceding, toComplete)
+	}
+
+	f := cmd.flagSet()
+	cmd.Setup(f, settings)
+
+	contextFlags := []string{"--context", "-x"}
+	if flag := f.Lookup("context"); flag != nil && flag.Shorthand != "" {
+		contextFlags = append(contextFlags, "-"+flag.Shorthand)
+	}
+	conn, _ := extractKubeConnection(cmdArgs, commandConnectionFlags(f))
+	conn.Context = coalesceString(extractValueArgumentFromArgs(cmdArgs, contextFlags...), settings.Context)
+
+	// The value of a flag, either `--flag=<TAB>` or `--flag <TAB>`
+	if name, value, hasValue := splitFlagArg(toComplete); hasValue && strings.HasPrefix(toComplete, "--") {
+		if flag := f.Lookup(strings.TrimPrefix(name, "--")); flag != nil {
+			values := completeFlagValue(settings, flag.Name, conn)
+			return prefixCompletions(filterCompletions(values, value), name+"="), completionDirectiveNoFileComp
+		}
+	}
+	if len(cmdArgs) > 0 && !cmd.DisableFlagParsing {
+		if flag := lookupPFlag(f, cmdArgs[len(cmdArgs)-1]); flag != nil && flag.Value.Type() != "bool" {
+			return filterCompletions(completeFlagValue(settings, flag.Name, conn), toComplete), completionDirectiveNoFileComp
+		}
+	}
+
+	if strings.HasPrefix(toComplete, "-") && !cmd.DisableFlagParsing {
+		completions := []string{}
+		f.VisitAll(func(flag *pflag.Flag) {
+			completions = append(completions, "--"+flag.Name+"\t"+flag.Usage)
+			if flag.Shorthand != "" {
+				completions = append(completions, "-"+flag.Shorthand+"\t"+flag.Usage)
+			}
+		})
+		return filterCompletions(completions, toComplete), completionDirectiveNoFileComp
+	}
+
+	if cmd.Complete != nil {
+		positional := cmdArgs
+		if !cmd.DisableFlagParsing {
+			f.Parse(cmdArgs)
+			positional = f.Args()
+		}
+		return filterCompletions(cmd.Complete(settings, positional, toComplete), toComplete), completionDirectiveNoFileComp
+	}
+	return nil, completionDirectiveNoFileComp
+}
+
+// completeKubectl asks kubectl for its completions and adds koi's own commands and flags
+func completeKubectl(settings Settings, preceding []string, toComplete string) ([]string, int) {
+	if name, value, hasValue := splitFlagArg(toComplete); hasValue && name == "--contexts" {
+		contexts, _ := listKubeContexts(KubeConnection{})
+		return prefixCompletions(filterCompletions(contexts, value), name+"="), completionDirectiveNoFileComp
+	}
+	if len(preceding) > 0 && preceding[len(preceding)-1] == "--contexts" {
+		contexts, _ := listKubeContexts(KubeConnection{})
+		return filterCompletions(contexts, toComplete), completionDirectiveNoFileComp
+	}
+	// kubectl does not know koi's flags
+	preceding, _ = ExtractYesFlag(preceding)
+	preceding, _ = ExtractContextsFlag(preceding)
+
+	kubectlArgs, _, _ := ApplyTweaksToArgs(settings, preceding)
+	completions, directive := runKubectlComplete(settings.KubectlExe, append(kubectlArgs, toComplete))
+
+	if strings.HasPrefix(toComplete, "-") {
+		completions = append(completions, filterCompletions(koiCompletionFlags, toComplete)...)
+	} else if cmd := findCommand(GetCommand(preceding)); cmd != nil && cmd.Complete != nil {
+		// Commands like config are shared with kubectl, so offer both sets of subcommands
+		positional := RemoveArg(preceding, cmd.Name)
+		if GetCommand(positional) != "" {
+			positional = []string{GetCommand(positional)}
+		} else {
+			positional = []string{}
+		}
+		completions = append(filterCompletions(cmd.Complete(settings, positional, toComplete), toComplete), completions...)
+	} else if GetCommand(preceding) == "" {
+		koiCommands := []string{}
+		for _, cmd := range Commands() {
+			if !cmd.Hidden {
+				koiCommands = append(koiCommands, cmd.Name+"\t"+cmd.Summary)
+			}
+		}
+		completions = append(filterCompletions(koiCommands, toComplete), completions...)
+	}
+	return completions, directive
+}
+
+func runKubectlComplete(exe string, args []string) ([]string, int) {
+	cmd := exec.Command(exe, append([]string{"__complete"}, args...)...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if err != nil {
+		log.Debugf("kubectl __complete failed: %v", err)
+		return nil, completionDirectiveNoFileComp
+	}
+
+	completions := []string{}
+	directive := completionDirectiveNoFileComp
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ":") {
+			if d, err := strconv.Atoi(strings.TrimPrefix(line, ":")); err == nil {
+				directive = d
+				continue
+			}
+		}
+		if line != "" {
+			completions = append(completions, line)
+		}
+	}
+	return completions, directive
+}
+
+// completeFlagValue completes values for the flags koi commands have in common
+func completeFlagValue(settings Settings, flagName string, conn KubeConnection) []string {
+	switch flagName {
+	case "template":
+		return sortedKeys(settings.ShellTemplates)
+	case "context", "contexts":
+		contexts, err := listKubeContexts(conn)
+		if err != nil {
+			log.Debugf("Failed to list contexts: %v", err)
+		}
+		return contexts
+	case "namespace":
+		namespaces, err := listNamespaces(conn, completionTimeout)
+		if err != nil {
+			log.Debugf("Failed to list namespaces: %v", err)
+		}
+		return namespaces
+	}
+	return nil
+}
+
+// lookupPFlag finds the flag for an arg like --name or -n, unless the arg already has its value
+func lookupPFlag(f *pflag.FlagSet, arg string) *pflag.Flag {
+	if strings.Contains(arg, "=") {
+		return nil
+	}
+	if strings.HasPrefix(arg, "--") {
+		return f.Lookup(strings.TrimPrefix(arg, "--"))
+	}
+	if strings.HasPrefix(arg, "-") && len(arg) == 2 {
+		return f.ShorthandLookup(arg[1:])
+	}
+	return nil
+}
+
+func filterCompletions(completions []string, prefix string) []string {
+	ret := []string{}
+	for _, c := range completions {
+		if strings.HasPrefix(c, prefix) {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func prefixCompletions(completions []string, prefix string) []string {
+	ret := make([]string, 0, len(completions))
+	for _, c := range completions {
+		ret = append(ret, prefix+c)
+	}
+	return ret
+}
+
+const bashCompletion = `# bash completion for koi, kshell and kcontainers
+# Load it with: source <(koi completion bash)
+__koi_complete() {
+    local cur words cword
+    if declare -F _get_comp_words_by_ref >/dev/null; then
+        _get_comp_words_by_ref -n =: cur words cword
+    else
+        cur="${COMP_WORDS[COMP_CWORD]}"
+        words=("${COMP_WORDS[@]}")
+        cword=$COMP_CWORD
+    fi
+
+    local prefix=()
+    case "${words[0]##*/}" in
+        kshell) prefix=(shell) ;;
+        kcontainers) prefix=(containers) ;;
+    esac
+
+    local out directive
+    out=$(koi __complete "${prefix[@]}" "${words[@]:1:cword-1}" "$cur" 2>/dev/null) || return
+    directive=${out##*:}
+    out=${out%:*}
+
+    if (( directive & 1 )); then
+        return
+    fi
+    if (( directive & 2 )); then
+        compopt -o nospace 2>/dev/null
+    fi
+
+    local IFS=$'\n' line
+    COMPREPLY=()
+    for line in $out; do
+        COMPREPLY+=("${line%%$'\t'*}")
+    done
+
+    if (( ${#COMPREPLY[@]} == 0 )) && (( (directive & 4) == 0 )); then
+        compopt -o default 2>/dev/null
+    fi
+    if declare -F __ltrim_colon_completions >/dev/null; then
+        __ltrim_colon_completions "$cur"
+    fi
+}
+complete -F __koi_complete koi kshell kcontainers
+`
+
+const zshCompletion = `#compdef koi kshell kcontainers
+# zsh completion for koi, kshell and kcontainers
+# Load it with: source <(koi completion zsh)
+_koi() {
+    local -a prefix completions
+    case ${words[1]:t} in
+        kshell) prefix=(shell) ;;
+        kcontainers) prefix=(containers) ;;
+    esac
+
+    local out directive line comp desc
+    out=$(koi __complete "${prefix[@]}" "${(@)words[2,CURRENT-1]}" "${words[CURRENT]}" 2>/dev/null) || return
+    directive=${out##*:}
+    out=${out%:*}
+
+    (( directive & 1 )) && return
+
+    for line in "${(@f)out}"; do
+        [[ -z $line ]] && continue
+        comp=${line%%$'\t'*}
+        desc=""
+        [[ $line == *$'\t'* ]] && desc=${line#*$'\t'}
+        completions+=("${comp//:/\\:}${desc:+:$desc}")
+    done
+
+    if (( ${#completions} )); then
+        if (( directive & 2 )); then
+            _describe -t koi 'koi' completions -S ''
+        else
+            _describe -t koi 'koi' completions
+        fi
+    elif (( (directive & 4) == 0 )); then
+        _files
+    fi
+}
+
+if [ "$funcstack[1]" = "_koi" ]; then
+    _koi "$@"
+else
+    compdef _koi koi kshell kcontainers
+fi
+`
+
+const fishCompletion = `# fish completion for koi, kshell and kcontainers
+# Load it with: koi completion fish | source
+function __koi_complete
+    set -l tokens (commandline -opc)
+    set -l current (commandline -ct)
+    set -l prefix
+    switch (basename $tokens[1])
+        case kshell
+            set prefix shell
+        case kcontainers
+            set prefix containers
+    end
+
+    set -l out (koi __complete $prefix $tokens[2..-1] $current 2>/dev/null)
+    or return
+    set -l directive (string replace ':' '' -- $out[-1])
+    set -e out[-1]
+
+    if test (math "$directive % 2") -eq 1
+        return
+    end
+    if test (count $out) -eq 0; and test (math "floor($directive / 4) % 2") -eq 0
+        __fish_complete_path $current
+        return
+    end
+    printf '%s\n' $out
+end
+
+for cmd in koi kshell kcontainers
+    complete -c $cmd -f -a '(__koi_complete)'
+end
+`
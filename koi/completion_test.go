@@ -0,0 +1,68 @@
+package koi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_completeArgs(t *testing.T) {
+	settings := Settings{Profile: Profile{KubectlExe: "koi-test-no-such-kubectl"}}
+
+	tests := []struct {
+		name          string
+		args          []string
+		want          []string
+		wantDirective int
+	}{
+		{
+			name:          "Flags of koi commands should be completed",
+			args:          []string{"shell", "--ima"},
+			want:          []string{"--image\tThe image to use"},
+			wantDirective: completionDirectiveNoFileComp,
+		},
+		{
+			name:          "koi commands should be completed when there is no command yet",
+			args:          []string{"-n", "koi", "ev"},
+			want:          []string{"events\tShow events sorted by time, hiding Normal events"},
+			wantDirective: completionDirectiveNoFileComp,
+		},
+		{
+			name:          "koi flags should be added to kubectl flags",
+			args:          []string{"get", "pods", "--yq"},
+			want:          []string{"--yq\tFilter the output with a jq expression and print it as yaml"},
+			wantDirective: completionDirectiveNoFileComp,
+		},
+		{
+			name:          "koi config subcommands should be completed",
+			args:          []string{"config", "use-p"},
+			want:          []string{"use-profile"},
+			wantDirective: completionDirectiveNoFileComp,
+		},
+		{
+			name:          "Positional args of koi commands should be completed",
+			args:          []string{"completion", "z"},
+			want:          []string{"zsh"},
+			wantDirective: completionDirectiveNoFileComp,
+		},
+		{
+			name:          "Hidden commands should not be completed",
+			args:          []string{"__"},
+			want:          []string{},
+			wantDirective: completionDirectiveNoFileComp,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotDirective := completeArgs(settings, tt.args)
+			if got == nil {
+				got = []string{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("completeArgs() got: %q, want: %q", got, tt.want)
+			}
+			if gotDirective != tt.wantDirective {
+				t.Errorf("completeArgs() directive got: %v, want: %v", gotDirective, tt.wantDirective)
+			}
+		})
+	}
+}
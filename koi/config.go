@@ -0,0 +1,324 @@
+package koi
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/util/homedir"
+)
+
+// Config is the content of the koi config file (~/.config/koi/config.yaml by default)
+type Config struct {
+	CurrentProfile string             `yaml:"currentProfile,omitempty"`
+	Profiles       map[string]Profile `yaml:"profiles,omitempty"`
+	// Aliases are expanded before anything else, eg: gp: get pods -o wide
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+	// Shorthands map extra short flags to long kubectl flags, eg: -N: --namespace
+	Shorthands map[string]string `yaml:"shorthands,omitempty"`
+	// Guardrails protect contexts such as production from mutating commands run by accident
+	Guardrails Guardrails `yaml:"guardrails,omitempty"`
+	// Audit configures the log of commands koi runs
+	Audit AuditConfig `yaml:"audit,omitempty"`
+	// ShellTemplates are kinds of shell pods for `koi shell --template NAME`
+	ShellTemplates map[string]ShellTemplate `yaml:"shellTemplates,omitempty"`
+}
+
+// Profile is a named set of defaults for koi
+type Profile struct {
+	Context    string            `yaml:"context,omitempty"`
+	Namespace  string            `yaml:"namespace,omitempty"`
+	KubectlExe string            `yaml:"kubectl,omitempty"`
+	LogLevel   string            `yaml:"logLevel,omitempty"`
+	FilterMode string            `yaml:"filterMode,omitempty"`
+	Output     map[string]string `yaml:"output,omitempty"`
+	Shell      ShellProfile      `yaml:"shell,omitempty"`
+}
+
+// ShellProfile holds the defaults for koi shell
+type ShellProfile struct {
+	Image         string `yaml:"image,omitempty"`
+	Name          string `yaml:"name,omitempty"`
+	ViMode        bool   `yaml:"viMode,omitempty"`
+	RequireReason bool   `yaml:"requireReason,omitempty"`
+	// TTL is how long shell pods can run, eg: 12h
+	TTL time.Duration `yaml:"ttl,omitempty"`
+}
+
+// Settings are the values koi runs with: the active profile with environment variables applied on top
+type Settings struct {
+	ConfigPath     string                   `yaml:"configPath"`
+	ProfileName    string                   `yaml:"profile,omitempty"`
+	Aliases        map[string]string        `yaml:"aliases,omitempty"`
+	Shorthands     map[string]string        `yaml:"shorthands,omitempty"`
+	Guardrails     Guardrails               `yaml:"guardrails,omitempty"`
+	Audit          AuditConfig              `yaml:"audit,omitempty"`
+	ShellTemplates map[string]ShellTemplate `yaml:"shellTemplates,omitempty"`
+	Profile        `yaml:",inline"`
+}
+
+const defaultShellImage = "oliverisaac/alpine-nettools:latest"
+
+// ConfigPath returns the location of the koi config file
+// It can be overridden with KOI_CONFIG
+func ConfigPath() string {
+	if path := os.Getenv("KOI_CONFIG"); path != "" {
+		return path
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(homedir.HomeDir(), ".config")
+	}
+	return filepath.Join(configHome, "koi", "config.yaml")
+}
+
+// LoadConfig reads the koi config file. A missing file is an empty config.
+func LoadConfig(path string) (Config, error) {
+	config := Config{}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, errors.Wrapf(err, "failed to read koi config %s", path)
+	}
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return config, errors.Wrapf(err, "failed to parse koi config %s", path)
+	}
+	return config, nil
+}
+
+// LoadSettings is the single place koi reads its configuration from
+// Precedence is: environment variables, then the terminal's session (koi ctx/ns), then the active profile, then built-in defaults
+// The active profile is KOI_PROFILE, then currentProfile from the config file, then a profile named "default"
+func LoadSettings() (Settings, error) {
+	settings := Settings{
+		ConfigPath: ConfigPath(),
+	}
+
+	config, err := LoadConfig(settings.ConfigPath)
+	if err != nil {
+		return settings, err
+	}
+
+	for short, long := range config.Shorthands {
+		if !strings.HasPrefix(short, "-") || strings.HasPrefix(short, "--") || !strings.HasPrefix(long, "--") {
+			return settings, fmt.Errorf("shorthand %q: %q in %s must map a short flag to a long flag", short, long, settings.ConfigPath)
+		}
+	}
+	settings.Aliases = config.Aliases
+	settings.Shorthands = config.Shorthands
+	settings.Guardrails = config.Guardrails
+	settings.Audit = config.Audit
+	settings.ShellTemplates = config.ShellTemplates
+
+	settings.ProfileName = coalesceString(os.Getenv("KOI_PROFILE"), config.CurrentProfile)
+	if settings.ProfileName != "" {
+		profile, ok := config.Profiles[settings.ProfileName]
+		if !ok {
+			return settings, fmt.Errorf("profile %q does not exist in %s", settings.ProfileName, settings.ConfigPath)
+		}
+		settings.Profile = profile
+	} else if profile, ok := config.Profiles["default"]; ok {
+		settings.ProfileName = "default"
+		settings.Profile = profile
+	}
+
+	session, err := LoadSessionState()
+	if err != nil {
+		return settings, err
+	}
+	settings.Context = coalesceString(session.Context, settings.Context)
+	settings.Namespace = coalesceString(session.Namespace, settings.Namespace)
+
+	overrideFromEnv(&settings.Context, "KOI_CONTEXT", "KOI_CTX")
+	overrideFromEnv(&settings.Namespace, "KOI_NAMESPACE", "KOI_NS")
+	overrideFromEnv(&settings.KubectlExe, "KOI_KUBECTL_EXE")
+	overrideFromEnv(&settings.LogLevel, "KOI_LOG_LEVEL")
+	overrideFromEnv(&settings.FilterMode, "KOI_FILTER_MODE")
+	overrideFromEnv(&settings.Shell.Image, "KSHELL_IMAGE")
+	overrideFromEnv(&settings.Shell.Name, "KSHELL_NAME")
+	overrideBoolFromEnv(&settings.Shell.ViMode, "KSHELL_VI_MODE")
+	overrideBoolFromEnv(&settings.Shell.RequireReason, "KOI_SHELL_REQUIRE_REASON")
+	overrideFromEnv(&settings.Audit.File, "KOI_AUDIT_FILE")
+
+	settings.KubectlExe = coalesceString(settings.KubectlExe, "kubectl")
+	settings.LogLevel = coalesceString(settings.LogLevel, "INFO")
+	settings.FilterMode = coalesceString(settings.FilterMode, "native")
+	settings.Shell.Image = coalesceString(settings.Shell.Image, defaultShellImage)
+
+	return settings, nil
+}
+
+func overrideFromEnv(target *string, envs ...string) {
+	for _, env := range envs {
+		if val := os.Getenv(env); val != "" {
+			*target = val
+			return
+		}
+	}
+}
+
+func overrideBoolFromEnv(target *bool, env string) {
+	if val, ok := os.LookupEnv(env); ok {
+		*target = val == "true"
+	}
+}
+
+// IsConfigSubcommand returns true if `koi config ...` is one of koi's own config commands
+// Anything else, such as `koi config use-context`, goes to kubectl
+func IsConfigSubcommand(args []string) bool {
+	switch GetCommand(RemoveArg(args, "config")) {
+	case "view", "use-profile", "get-profiles", "current-profile":
+		return true
+	}
+	return false
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:             "config",
+		Summary:          "View koi's settings and switch profiles (other subcommands go to kubectl config)",
+		Usage:            "koi config view|get-profiles|current-profile|use-profile NAME",
+		PassUnknownFlags: true,
+		Matches:          IsConfigSubcommand,
+		Complete: func(settings Settings, args []string, toComplete string) []string {
+			if len(args) == 0 {
+				return []string{"view", "get-profiles", "current-profile", "use-profile"}
+			}
+			if args[0] == "use-profile" && len(args) == 1 {
+				config, _ := LoadConfig(settings.ConfigPath)
+				return sortedKeys(config.Profiles)
+			}
+			return nil
+		},
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			raw := f.Bool("raw", false, "Print the config file instead of the resolved settings")
+			return func(inv Invocation) (int, error) {
+				return ConfigCommand(inv.Settings, *raw, inv.Args)
+			}
+		},
+	})
+}
+
+func ConfigCommand(settings Settings, raw bool, positional []string) (exitCode int, runError error) {
+	if len(positional) == 0 {
+		return 1, fmt.Errorf("no config subcommand given")
+	}
+
+	switch positional[0] {
+	case "view":
+		if raw {
+			config, err := LoadConfig(settings.ConfigPath)
+			if err != nil {
+				return 1, err
+			}
+			return printYAML(config)
+		}
+		return printYAML(settings)
+	case "current-profile":
+		if settings.ProfileName == "" {
+			return 1, fmt.Errorf("no profile is set in %s", settings.ConfigPath)
+		}
+		fmt.Println(settings.ProfileName)
+	case "get-profiles":
+		config, err := LoadConfig(settings.ConfigPath)
+		if err != nil {
+			return 1, err
+		}
+		for _, name := range sortedKeys(config.Profiles) {
+			marker := " "
+			if name == settings.ProfileName {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+	case "use-profile":
+		if len(positional) < 2 {
+			return 1, fmt.Errorf("usage: koi config use-profile NAME")
+		}
+		err := SetCurrentProfile(settings.ConfigPath, positional[1])
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("Switched to profile %q.\n", positional[1])
+	default:
+		return 1, fmt.Errorf("unknown config subcommand %q", positional[0])
+	}
+	return 0, nil
+}
+
+// SetCurrentProfile updates currentProfile in the config file, keeping the rest of the file (and its comments) as-is
+func SetCurrentProfile(path string, name string) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q does not exist in %s, available profiles: %q", name, path, sortedKeys(config.Profiles))
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read koi config %s", path)
+	}
+	var doc yaml.Node
+	err = yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse koi config %s", path)
+	}
+
+	root := doc.Content[0]
+	updated := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "currentProfile" {
+			root.Content[i+1].SetString(name)
+			updated = true
+		}
+	}
+	if !updated {
+		key := &yaml.Node{}
+		key.SetString("currentProfile")
+		val := &yaml.Node{}
+		val.SetString(name)
+		root.Content = append([]*yaml.Node{key, val}, root.Content...)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&doc)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode koi config")
+	}
+	encoder.Close()
+	return errors.Wrapf(os.WriteFile(path, buf.Bytes(), 0o644), "failed to write koi config %s", path)
+}
+
+func printYAML(obj interface{}) (exitCode int, runError error) {
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	err := encoder.Encode(obj)
+	if err != nil {
+		return 1, errors.Wrap(err, "failed to encode yaml")
+	}
+	return 0, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
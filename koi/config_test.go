@@ -0,0 +1,162 @@
+package koi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `# koi config used by tests
+currentProfile: work
+profiles:
+  work:
+    context: work-cluster
+    namespace: payments
+    kubectl: kubectl-1.30
+    shell:
+      requireReason: true
+  home:
+    context: kind-kind
+`
+
+func TestLoadSettings(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         string
+		env            map[string]string
+		session        *SessionState
+		wantProfile    string
+		wantContext    string
+		wantNamespace  string
+		wantKubectlExe string
+		wantReason     bool
+		wantErr        bool
+	}{
+		{
+			name:           "No config file should use the defaults",
+			config:         "",
+			wantKubectlExe: "kubectl",
+		},
+		{
+			name:           "The current profile should be used",
+			config:         testConfig,
+			wantProfile:    "work",
+			wantContext:    "work-cluster",
+			wantNamespace:  "payments",
+			wantKubectlExe: "kubectl-1.30",
+			wantReason:     true,
+		},
+		{
+			name:           "KOI_PROFILE should select a different profile",
+			config:         testConfig,
+			env:            map[string]string{"KOI_PROFILE": "home"},
+			wantProfile:    "home",
+			wantContext:    "kind-kind",
+			wantKubectlExe: "kubectl",
+		},
+		{
+			name:           "Environment variables should override the profile",
+			config:         testConfig,
+			env:            map[string]string{"KOI_CTX": "other", "KOI_KUBECTL_EXE": "oc", "KOI_SHELL_REQUIRE_REASON": "false"},
+			wantProfile:    "work",
+			wantContext:    "other",
+			wantNamespace:  "payments",
+			wantKubectlExe: "oc",
+			wantReason:     false,
+		},
+		{
+			name:           "The terminal's session should override the profile",
+			config:         testConfig,
+			session:        &SessionState{Context: "picked", Namespace: "checkout"},
+			wantProfile:    "work",
+			wantContext:    "picked",
+			wantNamespace:  "checkout",
+			wantKubectlExe: "kubectl-1.30",
+			wantReason:     true,
+		},
+		{
+			name:           "Environment variables should override the session",
+			config:         testConfig,
+			env:            map[string]string{"KOI_NS": "from-env"},
+			session:        &SessionState{Context: "picked", Namespace: "checkout"},
+			wantProfile:    "work",
+			wantContext:    "picked",
+			wantNamespace:  "from-env",
+			wantKubectlExe: "kubectl-1.30",
+			wantReason:     true,
+		},
+		{
+			name:    "A missing profile should be an error",
+			config:  testConfig,
+			env:     map[string]string{"KOI_PROFILE": "nope"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if tt.config != "" {
+				os.WriteFile(path, []byte(tt.config), 0o644)
+			}
+			t.Setenv("KOI_CONFIG", path)
+			t.Setenv("XDG_STATE_HOME", t.TempDir())
+			t.Setenv("KOI_SESSION", "test")
+			for key, val := range tt.env {
+				t.Setenv(key, val)
+			}
+			if tt.session != nil {
+				if err := SaveSessionState(*tt.session); err != nil {
+					t.Fatalf("SaveSessionState() error = %v", err)
+				}
+			}
+
+			got, err := LoadSettings()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.ProfileName != tt.wantProfile {
+				t.Errorf("LoadSettings() profile got: %v, want: %v", got.ProfileName, tt.wantProfile)
+			}
+			if got.Context != tt.wantContext {
+				t.Errorf("LoadSettings() context got: %v, want: %v", got.Context, tt.wantContext)
+			}
+			if got.Namespace != tt.wantNamespace {
+				t.Errorf("LoadSettings() namespace got: %v, want: %v", got.Namespace, tt.wantNamespace)
+			}
+			if got.KubectlExe != tt.wantKubectlExe {
+				t.Errorf("LoadSettings() kubectl got: %v, want: %v", got.KubectlExe, tt.wantKubectlExe)
+			}
+			if got.Shell.RequireReason != tt.wantReason {
+				t.Errorf("LoadSettings() requireReason got: %v, want: %v", got.Shell.RequireReason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestSetCurrentProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(testConfig), 0o644)
+
+	if err := SetCurrentProfile(path, "nope"); err == nil {
+		t.Errorf("SetCurrentProfile() should fail for a missing profile")
+	}
+
+	if err := SetCurrentProfile(path, "home"); err != nil {
+		t.Fatalf("SetCurrentProfile() error = %v", err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.CurrentProfile != "home" {
+		t.Errorf("SetCurrentProfile() currentProfile got: %v, want: home", config.CurrentProfile)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), "# koi config used by tests") {
+		t.Errorf("SetCurrentProfile() should keep comments, got: %s", content)
+	}
+}
@@ -1,156 +1,629 @@
 package koi
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/pkg/errors"
 	"github.com/rodaine/table"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	"k8s.io/apimachinery/pkg/util/duration"
 )
 
-func ContainersCommand(args []string) (exitCode int, runError error) {
-	flags := pflag.NewFlagSet("kcontainers", pflag.ExitOnError)
+type ContainersOptions struct {
+	kubeContext   string
+	contexts      string
+	allContexts   bool
+	timeout       time.Duration
+	namespace     string
+	allNamespaces bool
+	writeInColor  bool
+	columns       []string
+	output        string
+	selector      string
+	fieldSelector string
+	node          string
+	statuses      []string
+	notReady      bool
+	image         string
+	watch         bool
+}
 
-	var kubeContext string
-	flags.StringVarP(&kubeContext, "context", "c", "", "Context to get contianers in")
+// Container types, sidecars are init containers which keep running alongside the app
+const (
+	containerTypeApp       = "app"
+	containerTypeInit      = "init"
+	containerTypeSidecar   = "sidecar"
+	containerTypeEphemeral = "ephemeral"
+)
 
-	var namespace string
-	flags.StringVarP(&namespace, "namespace", "n", "", "Namespace to get contianers in")
+// ContainerInfo is one container of a pod with its spec and status
+type ContainerInfo struct {
+	// Context is only set when containers are listed from several contexts
+	Context   string `json:"context,omitempty" yaml:"context,omitempty"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Pod       string `json:"pod" yaml:"pod"`
+	Container string `json:"container" yaml:"container"`
+	Type      string `json:"type" yaml:"type"`
+	Node      string `json:"node,omitempty" yaml:"node,omitempty"`
+	// Status is ready, running, waiting, terminated or unknown
+	Status   string `json:"status" yaml:"status"`
+	Ready    bool   `json:"ready" yaml:"ready"`
+	Restarts int32  `json:"restarts" yaml:"restarts"`
+	// Reason explains a waiting or terminated container, eg: CrashLoopBackOff
+	Reason   string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	ExitCode *int32 `json:"exitCode,omitempty" yaml:"exitCode,omitempty"`
+	// LastReason and LastExitCode are from the previous run of a restarted container, eg: OOMKilled 137
+	LastReason   string            `json:"lastReason,omitempty" yaml:"lastReason,omitempty"`
+	LastExitCode *int32            `json:"lastExitCode,omitempty" yaml:"lastExitCode,omitempty"`
+	Image        string            `json:"image" yaml:"image"`
+	ImageID      string            `json:"imageID,omitempty" yaml:"imageID,omitempty"`
+	StartedAt    *time.Time        `json:"startedAt,omitempty" yaml:"startedAt,omitempty"`
+	Requests     map[string]string `json:"requests,omitempty" yaml:"requests,omitempty"`
+	Limits       map[string]string `json:"limits,omitempty" yaml:"limits,omitempty"`
+	// Error is set on the row for a context which could not be reached, instead of a container
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
 
-	var allNamespaces bool
-	flags.BoolVarP(&allNamespaces, "all-namespaces", "A", false, "Get containers in all namespaces")
+// containerColumn is a column kcontainers can show
+type containerColumn struct {
+	name   string
+	header string
+	value  func(c ContainerInfo, now time.Time) string
+}
 
-	var writeInColor bool
-	flags.BoolVar(&writeInColor, "color", WritingToTerminal(), "Configure color output")
+var containerColumns = []containerColumn{
+	{"context", "Context", func(c ContainerInfo, now time.Time) string { return c.Context }},
+	{"namespace", "Namespace", func(c ContainerInfo, now time.Time) string { return c.Namespace }},
+	{"pod", "Pod", func(c ContainerInfo, now time.Time) string { return c.Pod }},
+	{"container", "Container", func(c ContainerInfo, now time.Time) string { return c.Container }},
+	{"type", "Type", func(c ContainerInfo, now time.Time) string { return c.Type }},
+	{"init", "Init", func(c ContainerInfo, now time.Time) string {
+		return fmt.Sprint(c.Type == containerTypeInit || c.Type == containerTypeSidecar)
+	}},
+	{"status", "Status", func(c ContainerInfo, now time.Time) string { return c.Status }},
+	{"ready", "Ready", func(c ContainerInfo, now time.Time) string { return fmt.Sprint(c.Ready) }},
+	{"restarts", "Restarts", func(c ContainerInfo, now time.Time) string { return fmt.Sprint(c.Restarts) }},
+	{"reason", "Reason", func(c ContainerInfo, now time.Time) string {
+		if c.Error != "" {
+			return c.Error
+		}
+		return withExitCode(c.Reason, c.ExitCode)
+	}},
+	{"last-reason", "Last Reason", func(c ContainerInfo, now time.Time) string { return withExitCode(c.LastReason, c.LastExitCode) }},
+	{"age", "Age", func(c ContainerInfo, now time.Time) string {
+		if c.StartedAt == nil {
+			return ""
+		}
+		return duration.HumanDuration(now.Sub(*c.StartedAt))
+	}},
+	{"image", "Image", func(c ContainerInfo, now time.Time) string { return c.Image }},
+	{"digest", "Digest", func(c ContainerInfo, now time.Time) string { return shortDigest(c.ImageID) }},
+	{"node", "Node", func(c ContainerInfo, now time.Time) string { return c.Node }},
+	{"requests", "Requests", func(c ContainerInfo, now time.Time) string { return formatResources(c.Requests) }},
+	{"limits", "Limits", func(c ContainerInfo, now time.Time) string { return formatResources(c.Limits) }},
+}
 
-	err := flags.Parse(args)
-	if err != nil {
-		return 1, errors.Wrap(err, "parsing flags")
+var defaultContainerColumns = []string{"namespace", "pod", "container", "type", "status", "restarts", "reason", "age"}
+
+func containerColumnNames() []string {
+	names := make([]string, 0, len(containerColumns))
+	for _, column := range containerColumns {
+		names = append(names, column.name)
 	}
+	return names
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:     "containers",
+		Symlinks: []string{"kcontainers"},
+		Summary:  "List the containers of every pod with their status",
+		Usage:    "koi containers [flags] [POD...]",
+		Setup: func(flags *pflag.FlagSet, settings Settings) RunFunc {
+			opts := ContainersOptions{}
+			flags.StringVarP(&opts.kubeContext, "context", "c", "", "Context to get contianers in")
+			flags.StringVar(&opts.contexts, "contexts", "", "Get containers in every context matching a comma separated list of globs, eg: prod-*,staging")
+			flags.BoolVar(&opts.allContexts, "all-contexts", false, "Get containers in every context of the kubeconfig")
+			flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "How long to wait for each cluster")
+			flags.StringVarP(&opts.namespace, "namespace", "n", "", "Namespace to get contianers in")
+			flags.BoolVarP(&opts.allNamespaces, "all-namespaces", "A", false, "Get containers in all namespaces")
+			flags.BoolVar(&opts.writeInColor, "color", WritingToTerminal(), "Configure color output")
+			flags.StringSliceVar(&opts.columns, "columns", defaultContainerColumns, fmt.Sprintf("Columns to show, from: %s", strings.Join(containerColumnNames(), ", ")))
+			flags.StringVarP(&opts.output, "output", "o", "", "Output format: wide, json, yaml, csv, tsv or name. json and yaml are a list with every field of each container")
+			flags.StringVarP(&opts.selector, "selector", "l", "", "Only show pods matching this label selector, eg: app=payments")
+			flags.StringVar(&opts.fieldSelector, "field-selector", "", "Only show pods matching this field selector, eg: status.phase=Running")
+			flags.StringVar(&opts.node, "node", "", "Only show pods on this node")
+			flags.StringSliceVar(&opts.statuses, "status", nil, "Only show containers with this status: ready, running, waiting, terminated or unknown")
+			flags.BoolVar(&opts.notReady, "not-ready", false, "Only show containers which are not ready, init containers which completed are left out")
+			flags.StringVar(&opts.image, "image", "", "Only show containers whose image matches this glob, eg: *nginx*")
+			flags.BoolVarP(&opts.watch, "watch", "w", false, "Keep the table up to date until Ctrl-C, when not writing to a terminal a line is written for each change")
+			return func(inv Invocation) (int, error) {
+				return ContainersCommand(inv, opts)
+			}
+		},
+	})
+}
 
+func ContainersCommand(inv Invocation, opts ContainersOptions) (exitCode int, runError error) {
 	logrus := logrus.WithFields(logrus.Fields{
-		"context":        kubeContext,
-		"namespace":      namespace,
-		"all-namespaces": allNamespaces,
+		"context":        opts.kubeContext,
+		"namespace":      opts.namespace,
+		"all-namespaces": opts.allNamespaces,
 	})
 
 	logrus.Debug("Going to run kcontainers")
 
-	if allNamespaces {
+	multipleContexts := opts.contexts != "" || opts.allContexts
+	if opts.output == "wide" {
+		opts.columns = containerColumnNames()
+		if !multipleContexts {
+			// The context column comes first
+			opts.columns = opts.columns[1:]
+		}
+	}
+	// The context column is added when listing several contexts, unless the columns already have it
+	if multipleContexts && !stringArrayContains(opts.columns, "context") {
+		opts.columns = append([]string{"context"}, opts.columns...)
+	}
+	columns, err := selectContainerColumns(opts.columns)
+	if err != nil {
+		return 1, err
+	}
+
+	namespace := opts.namespace
+	if opts.allNamespaces {
 		namespace = ""
 	}
 
-	pods, err := getPodsByNamespace(kubeContext, namespace)
+	podPatterns, err := compilePodPatterns(inv.Args)
 	if err != nil {
-		return -1, errors.Wrap(err, "getting pods")
+		return 1, err
 	}
 
-	tbl := table.New("Namespace", "Pod", "Container", "Init", "Status")
+	fieldSelector := opts.fieldSelector
+	if opts.node != "" {
+		fieldSelector = strings.Trim(fieldSelector+",spec.nodeName="+opts.node, ",")
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: opts.selector,
+		FieldSelector: fieldSelector,
+	}
+	// --contexts wins over --context, which may only be the default from the settings
+	conn := inv.Connection
+	conn.Context = opts.kubeContext
 
-	for _, pod := range pods {
-		ns := pod.GetNamespace()
-		podName := pod.GetName()
-		statusByContainer := map[string]string{}
-
-		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
-			statusName := "unknown"
-			statusColor := color.WhiteString
-			if status.Ready {
-				statusName = "ready"
-				statusColor = color.GreenString
-			} else if status.State.Running != nil {
-				statusName = "running"
-				statusColor = color.CyanString
-			} else if status.State.Terminated != nil {
-				statusName = "terminated"
-				statusColor = color.MagentaString
-			} else if status.State.Waiting != nil {
-				statusName = "waiting"
-				statusColor = color.YellowString
+	var containers []ContainerInfo
+	if multipleContexts {
+		if opts.watch {
+			return 1, fmt.Errorf("--watch only works with a single context")
+		}
+		contexts, err := listKubeContexts(conn)
+		if err != nil {
+			return 1, err
+		}
+		if !opts.allContexts {
+			contexts, err = matchContexts(opts.contexts, contexts)
+			if err != nil {
+				return 1, err
 			}
+		}
+		if inv.Audit != nil {
+			inv.Audit.Context = strings.Join(contexts, ",")
+		}
+		containers = listContainersInContexts(conn, contexts, namespace, listOptions, podPatterns, opts)
+	} else {
+		if opts.watch {
+			return watchContainers(conn, opts, namespace, listOptions, podPatterns, columns)
+		}
 
-			if !writeInColor {
-				statusColor = fmt.Sprintf
-			}
-			statusByContainer[status.Name] = statusColor("%s", statusName)
+		ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+		defer cancel()
+		pods, err := getPodsByNamespace(ctx, conn, namespace, listOptions)
+		if err != nil {
+			return -1, errors.Wrap(err, "getting pods")
 		}
+		containers = filterContainers(collectContainers(filterPods(pods, podPatterns)), opts)
+	}
 
-		for _, container := range pod.Spec.InitContainers {
-			status, ok := statusByContainer[container.Name]
-			if !ok {
-				status = "unknown"
+	// --jq and --yq set the output to json, the filter then gets the same List as -o json
+	if inv.Filter != nil {
+		var buf bytes.Buffer
+		err = writeContainerRecords(&buf, containers)
+		if err != nil {
+			return 1, err
+		}
+		err = inv.Filter.Filter(&buf, os.Stdout)
+		if err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
+	err = writeContainers(os.Stdout, opts.output, containers, columns, opts.writeInColor, namespace == "")
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// listContainersInContexts lists the containers in every context at the same time
+// A context which cannot be reached becomes a warning row, so one broken cluster does not hide the others
+func listContainersInContexts(conn KubeConnection, contexts []string, namespace string, listOptions metav1.ListOptions, podPatterns []*regexp.Regexp, opts ContainersOptions) []ContainerInfo {
+	results := make([][]ContainerInfo, len(contexts))
+	var wg sync.WaitGroup
+	for i, kubeContext := range contexts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			contextConn := conn
+			contextConn.Context = kubeContext
+			ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+			defer cancel()
+
+			pods, err := getPodsByNamespace(ctx, contextConn, namespace, listOptions)
+			if err != nil {
+				logrus.Warnf("Could not get containers in context %q: %v", kubeContext, err)
+				results[i] = []ContainerInfo{{Context: kubeContext, Status: "unreachable", Error: err.Error()}}
+				return
+			}
+			containers := filterContainers(collectContainers(filterPods(pods, podPatterns)), opts)
+			for j := range containers {
+				containers[j].Context = kubeContext
+			}
+			results[i] = containers
+		}()
+	}
+	wg.Wait()
+
+	ret := []ContainerInfo{}
+	for _, containers := range results {
+		ret = append(ret, containers...)
+	}
+	return ret
+}
+
+// containerList holds the containers for json and yaml, in the shape of a kubectl list
+type containerList struct {
+	APIVersion string          `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string          `json:"kind" yaml:"kind"`
+	Items      []ContainerInfo `json:"items" yaml:"items"`
+}
+
+// writeContainers writes the containers in the output format, the columns are used by the table, csv and tsv
+// withNamespace adds the namespace to the name output, for containers listed across namespaces
+func writeContainers(w io.Writer, output string, containers []ContainerInfo, columns []containerColumn, writeInColor bool, withNamespace bool) error {
+	now := time.Now()
+	list := containerList{APIVersion: "v1", Kind: "List", Items: containers}
+	if list.Items == nil {
+		list.Items = []ContainerInfo{}
+	}
+	switch output {
+	case "", "wide":
+		printContainersTable(w, containers, columns, writeInColor, now)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		if err := encoder.Encode(list); err != nil {
+			return errors.Wrap(err, "failed to encode json")
+		}
+	case "yaml":
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		if err := encoder.Encode(list); err != nil {
+			return errors.Wrap(err, "failed to encode yaml")
+		}
+	case "csv", "tsv":
+		writer := csv.NewWriter(w)
+		if output == "tsv" {
+			writer.Comma = '\t'
+		}
+		header := make([]string, 0, len(columns))
+		for _, column := range columns {
+			header = append(header, column.name)
+		}
+		writer.Write(header)
+		for _, container := range containers {
+			record := make([]string, 0, len(columns))
+			for _, column := range columns {
+				record = append(record, column.value(container, now))
 			}
-			tbl.AddRow(ns, podName, container.Name, true, status)
+			writer.Write(record)
 		}
+		writer.Flush()
+		return errors.Wrapf(writer.Error(), "failed to write %s", output)
+	case "name":
+		for _, container := range containers {
+			if container.Error != "" {
+				continue
+			}
+			if withNamespace {
+				fmt.Fprintf(w, "%s/%s/%s\n", container.Namespace, container.Pod, container.Container)
+			} else {
+				fmt.Fprintf(w, "%s/%s\n", container.Pod, container.Container)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown output format %q, use wide, json, yaml, csv, tsv or name", output)
+	}
+	return nil
+}
 
-		for _, container := range pod.Spec.Containers {
-			status, ok := statusByContainer[container.Name]
-			if !ok {
-				status = "unknown"
+// writeContainerRecords writes the List which -o json prints, the input --jq and --yq get like every other command
+func writeContainerRecords(w io.Writer, containers []ContainerInfo) error {
+	return writeContainers(w, "json", containers, nil, false, false)
+}
+
+func printContainersTable(w io.Writer, containers []ContainerInfo, columns []containerColumn, writeInColor bool, now time.Time) {
+	headers := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		headers = append(headers, column.header)
+	}
+	tbl := table.New(headers...).WithWriter(w)
+
+	for _, container := range containers {
+		row := make([]interface{}, 0, len(columns))
+		for _, column := range columns {
+			value := column.value(container, now)
+			if column.name == "status" && writeInColor {
+				value = statusColor(container.Status)("%s", value)
 			}
-			tbl.AddRow(ns, podName, container.Name, false, status)
+			row = append(row, value)
 		}
+		tbl.AddRow(row...)
 	}
 
 	if writeInColor {
 		tbl.WithHeaderFormatter(color.New(color.FgHiWhite, color.Underline).SprintfFunc())
 	}
 	tbl.Print()
+}
 
-	return 0, nil
+// compilePodPatterns turns the pod args into regular expressions anchored at the start of the name
+// A plain name is a prefix, eg: api matches api-7d9f-abc
+func compilePodPatterns(args []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(args))
+	for _, arg := range args {
+		pattern, err := regexp.Compile("^(?:" + arg + ")")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pod pattern %q", arg)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
 }
 
-func WritingToTerminal() bool {
-	fi, err := os.Stdout.Stat()
-	if err != nil {
-		panic(err)
+// filterPods returns the pods whose name matches any of the patterns, or every pod when there are none
+func filterPods(pods []v1.Pod, patterns []*regexp.Regexp) []v1.Pod {
+	if len(patterns) == 0 {
+		return pods
 	}
-	return fi.Mode()&os.ModeCharDevice != 0
+	ret := []v1.Pod{}
+	for _, pod := range pods {
+		for _, pattern := range patterns {
+			if pattern.MatchString(pod.GetName()) {
+				ret = append(ret, pod)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// filterContainers applies the container level filters, which the API server cannot do for us
+func filterContainers(containers []ContainerInfo, opts ContainersOptions) []ContainerInfo {
+	ret := []ContainerInfo{}
+	for _, container := range containers {
+		if len(opts.statuses) > 0 && !stringArrayContains(opts.statuses, container.Status) {
+			continue
+		}
+		if opts.notReady && (container.Ready || container.completed()) {
+			continue
+		}
+		if opts.image != "" && !globMatch(opts.image, container.Image) {
+			continue
+		}
+		ret = append(ret, container)
+	}
+	return ret
+}
+
+// completed is true for an init container which finished successfully, it is never going to be ready
+func (c ContainerInfo) completed() bool {
+	return c.Type == containerTypeInit && c.Status == "terminated" && c.ExitCode != nil && *c.ExitCode == 0
+}
+
+func selectContainerColumns(names []string) ([]containerColumn, error) {
+	columns := []containerColumn{}
+	for _, name := range names {
+		found := false
+		for _, column := range containerColumns {
+			if column.name == strings.ToLower(strings.TrimSpace(name)) {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q, available columns: %s", name, strings.Join(containerColumnNames(), ", "))
+		}
+	}
+	return columns, nil
+}
+
+func statusColor(status string) func(format string, a ...interface{}) string {
+	switch status {
+	case "ready":
+		return color.GreenString
+	case "running":
+		return color.CyanString
+	case "terminated":
+		return color.MagentaString
+	case "waiting":
+		return color.YellowString
+	case "unreachable":
+		return color.RedString
+	}
+	return color.WhiteString
+}
+
+// collectContainers returns every init, sidecar, app and ephemeral container of the pods, in pod order
+func collectContainers(pods []v1.Pod) []ContainerInfo {
+	ret := []ContainerInfo{}
+	for _, pod := range pods {
+		statuses := map[string]v1.ContainerStatus{}
+		for _, status := range append(append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...), pod.Status.EphemeralContainerStatuses...) {
+			statuses[status.Name] = status
+		}
+
+		for _, container := range pod.Spec.InitContainers {
+			containerType := containerTypeInit
+			if container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways {
+				containerType = containerTypeSidecar
+			}
+			ret = append(ret, newContainerInfo(pod, container, containerType, statuses))
+		}
+		for _, container := range pod.Spec.Containers {
+			ret = append(ret, newContainerInfo(pod, container, containerTypeApp, statuses))
+		}
+		for _, container := range pod.Spec.EphemeralContainers {
+			ret = append(ret, newContainerInfo(pod, v1.Container(container.EphemeralContainerCommon), containerTypeEphemeral, statuses))
+		}
+	}
+	return ret
 }
 
-func getKubeClient(kubeContext string) (*kubernetes.Clientset, error) {
-	kubeconfigPath, ok := os.LookupEnv("KUBE_CONFIG")
+func newContainerInfo(pod v1.Pod, container v1.Container, containerType string, statuses map[string]v1.ContainerStatus) ContainerInfo {
+	info := ContainerInfo{
+		Namespace: pod.GetNamespace(),
+		Pod:       pod.GetName(),
+		Container: container.Name,
+		Type:      containerType,
+		Node:      pod.Spec.NodeName,
+		Status:    "unknown",
+		Image:     container.Image,
+		Requests:  resourceStrings(container.Resources.Requests),
+		Limits:    resourceStrings(container.Resources.Limits),
+	}
+
+	status, ok := statuses[container.Name]
 	if !ok {
-		kubeconfigPath = filepath.Join(homedir.HomeDir(), ".kube", "config")
+		return info
 	}
-	if _, err := os.Stat(kubeconfigPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("kubeconfig not found at %s", kubeconfigPath)
+
+	info.Ready = status.Ready
+	info.Restarts = status.RestartCount
+	info.ImageID = status.ImageID
+	if status.Ready {
+		info.Status = "ready"
+	} else if status.State.Running != nil {
+		info.Status = "running"
+	} else if status.State.Terminated != nil {
+		info.Status = "terminated"
+	} else if status.State.Waiting != nil {
+		info.Status = "waiting"
 	}
 
-	// Load the kubeconfig with the specified context
-	configOverrides := &clientcmd.ConfigOverrides{
-		CurrentContext: kubeContext,
+	if running := status.State.Running; running != nil {
+		info.StartedAt = timePointer(running.StartedAt)
+	}
+	if terminated := status.State.Terminated; terminated != nil {
+		info.Reason = terminated.Reason
+		info.ExitCode = &terminated.ExitCode
+		info.StartedAt = timePointer(terminated.StartedAt)
+	}
+	if waiting := status.State.Waiting; waiting != nil {
+		info.Reason = waiting.Reason
 	}
-	loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath}
-	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+	if last := status.LastTerminationState.Terminated; last != nil {
+		info.LastReason = last.Reason
+		info.LastExitCode = &last.ExitCode
+	}
+	return info
+}
 
-	restConfig, err := config.ClientConfig()
-	if err != nil {
-		return nil, fmt.Errorf("building rest config: %w", err)
+func timePointer(t metav1.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t.Time
+}
+
+func resourceStrings(resources v1.ResourceList) map[string]string {
+	if len(resources) == 0 {
+		return nil
+	}
+	ret := map[string]string{}
+	for name, quantity := range resources {
+		ret[string(name)] = quantity.String()
+	}
+	return ret
+}
+
+// formatResources writes resources as cpu=100m,memory=128Mi
+func formatResources(resources map[string]string) string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+resources[name])
 	}
+	return strings.Join(parts, ",")
+}
 
-	// Create clientset
-	clientset, err := kubernetes.NewForConfig(restConfig)
+func withExitCode(reason string, exitCode *int32) string {
+	if exitCode == nil {
+		return reason
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %d", reason, *exitCode))
+}
+
+// shortDigest returns the start of the digest from an image ID, eg: sha256:4c1e0e8d3f2a
+func shortDigest(imageID string) string {
+	digest := imageID
+	if i := strings.LastIndex(digest, "@"); i >= 0 {
+		digest = digest[i+1:]
+	}
+	if len(digest) > len("sha256:")+12 {
+		digest = digest[:len("sha256:")+12]
+	}
+	return digest
+}
+
+func WritingToTerminal() bool {
+	fi, err := os.Stdout.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("creating clientset: %w", err)
+		panic(err)
 	}
-	return clientset, nil
+	return fi.Mode()&os.ModeCharDevice != 0
 }
 
-func getPodsByNamespace(kubeContext string, namespace string) ([]v1.Pod, error) {
-	client, err := getKubeClient(kubeContext)
+func getPodsByNamespace(ctx context.Context, conn KubeConnection, namespace string, listOptions metav1.ListOptions) ([]v1.Pod, error) {
+	client, err := getKubeClient(conn)
+	if err != nil {
+		return nil, err
+	}
 
-	podList, err := client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	podList, err := client.CoreV1().Pods(namespace).List(ctx, listOptions)
 	if err != nil {
 		return nil, fmt.Errorf("listing pods: %w", err)
 	}
@@ -0,0 +1,311 @@
+package koi
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPod() v1.Pod {
+	always := v1.ContainerRestartPolicyAlways
+	started := metav1.NewTime(time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC))
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "payments"},
+		Spec: v1.PodSpec{
+			NodeName: "node-1",
+			InitContainers: []v1.Container{
+				{Name: "migrate", Image: "api:1.2"},
+				{Name: "proxy", Image: "envoy:1.30", RestartPolicy: &always},
+			},
+			Containers: []v1.Container{
+				{Name: "api", Image: "api:1.2", Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("128Mi")},
+					Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("256Mi")},
+				}},
+			},
+			EphemeralContainers: []v1.EphemeralContainer{
+				{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"}},
+			},
+		},
+		Status: v1.PodStatus{
+			InitContainerStatuses: []v1.ContainerStatus{
+				{Name: "migrate", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "Completed", ExitCode: 0}}},
+				{Name: "proxy", Ready: true, State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: started}}},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name:                 "api",
+					RestartCount:         4,
+					ImageID:              "docker.io/library/api@sha256:4c1e0e8d3f2a9b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c",
+					State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+					LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				},
+			},
+		},
+	}
+}
+
+func Test_collectContainers(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	columns, err := selectContainerColumns([]string{"container", "type", "status", "restarts", "reason", "last-reason", "age", "digest", "node", "requests", "limits"})
+	if err != nil {
+		t.Fatalf("selectContainerColumns() error = %v", err)
+	}
+
+	want := [][]string{
+		{"migrate", "init", "terminated", "0", "Completed 0", "", "", "", "node-1", "", ""},
+		{"proxy", "sidecar", "ready", "0", "", "", "60m", "", "node-1", "", ""},
+		{"api", "app", "waiting", "4", "CrashLoopBackOff", "OOMKilled 137", "", "sha256:4c1e0e8d3f2a", "node-1", "cpu=100m,memory=128Mi", "memory=256Mi"},
+		{"debugger", "ephemeral", "unknown", "0", "", "", "", "", "node-1", "", ""},
+	}
+
+	got := [][]string{}
+	for _, container := range collectContainers([]v1.Pod{testPod()}) {
+		row := []string{}
+		for _, column := range columns {
+			row = append(row, column.value(container, now))
+		}
+		got = append(got, row)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectContainers() got: %v, want: %v", got, want)
+	}
+}
+
+func Test_selectContainerColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "The default columns should all exist",
+			columns: defaultContainerColumns,
+			want:    "Namespace,Pod,Container,Type,Status,Restarts,Reason,Age",
+		},
+		{
+			name:    "Columns should ignore case",
+			columns: []string{"Pod", "IMAGE"},
+			want:    "Pod,Image",
+		},
+		{
+			name:    "An unknown column should be an error",
+			columns: []string{"pod", "colour"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectContainerColumns(tt.columns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectContainerColumns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			headers := []string{}
+			for _, column := range got {
+				headers = append(headers, column.header)
+			}
+			if strings.Join(headers, ",") != tt.want {
+				t.Errorf("selectContainerColumns() got: %v, want: %v", strings.Join(headers, ","), tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeContainers(t *testing.T) {
+	containers := collectContainers([]v1.Pod{testPod()})[1:3]
+	columns, _ := selectContainerColumns([]string{"pod", "container", "reason"})
+	tests := []struct {
+		name          string
+		output        string
+		withNamespace bool
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:   "csv should have a header and quote as needed",
+			output: "csv",
+			want:   "pod,container,reason\napi-1,proxy,\napi-1,api,CrashLoopBackOff\n",
+		},
+		{
+			name:   "tsv should be tab separated",
+			output: "tsv",
+			want:   "pod\tcontainer\treason\napi-1\tproxy\t\napi-1\tapi\tCrashLoopBackOff\n",
+		},
+		{
+			name:   "name should be pod/container",
+			output: "name",
+			want:   "api-1/proxy\napi-1/api\n",
+		},
+		{
+			name:          "name should have the namespace across namespaces",
+			output:        "name",
+			withNamespace: true,
+			want:          "payments/api-1/proxy\npayments/api-1/api\n",
+		},
+		{
+			name:    "An unknown format should be an error",
+			output:  "xml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeContainers(&buf, tt.output, containers, columns, false, tt.withNamespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeContainers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("writeContainers() got: %q, want: %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeContainers_list(t *testing.T) {
+	containers := collectContainers([]v1.Pod{testPod()})[1:3]
+	for _, output := range []string{"json", "yaml"} {
+		t.Run(output, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeContainers(&buf, output, containers, nil, false, false)
+			if err != nil {
+				t.Fatalf("writeContainers() error = %v", err)
+			}
+			got := containerList{}
+			if output == "json" {
+				err = json.Unmarshal(buf.Bytes(), &got)
+			} else {
+				err = yaml.Unmarshal(buf.Bytes(), &got)
+			}
+			if err != nil {
+				t.Fatalf("writeContainers() should write one %s document: %v\n%s", output, err, buf.String())
+			}
+			if got.Kind != "List" || !reflect.DeepEqual(got.Items, containers) {
+				t.Errorf("writeContainers() got: %+v, want a List of: %+v", got, containers)
+			}
+		})
+	}
+}
+
+func Test_writeContainerRecords(t *testing.T) {
+	containers := collectContainers([]v1.Pod{testPod()})[1:3]
+	var buf bytes.Buffer
+	if err := writeContainerRecords(&buf, containers); err != nil {
+		t.Fatalf("writeContainerRecords() error = %v", err)
+	}
+
+	// The filter should get the same List as -o json, so .items[] works like every other command
+	filter, err := NewOutputFilter("jq", ".items[] | select(.restarts > 0) | .container", false)
+	if err != nil {
+		t.Fatalf("NewOutputFilter() error = %v", err)
+	}
+	var out bytes.Buffer
+	if err := filter.Filter(&buf, &out); err != nil {
+		t.Fatalf("Filter() error = %v", err)
+	}
+	want := "api\n"
+	if out.String() != want {
+		t.Errorf("writeContainerRecords() filtered got: %q, want: %q", out.String(), want)
+	}
+}
+
+func Test_filterContainers(t *testing.T) {
+	pods := []v1.Pod{testPod()}
+	other := testPod()
+	other.Name = "web-1"
+	other.Spec.Containers[0].Image = "docker.io/library/nginx:1.27"
+	pods = append(pods, other)
+
+	tests := []struct {
+		name     string
+		patterns []string
+		opts     ContainersOptions
+		want     []string
+	}{
+		{
+			name: "No filters should return every container",
+			want: []string{"api-1/migrate", "api-1/proxy", "api-1/api", "api-1/debugger", "web-1/migrate", "web-1/proxy", "web-1/api", "web-1/debugger"},
+		},
+		{
+			name:     "A pod pattern should match the start of the name",
+			patterns: []string{"we"},
+			opts:     ContainersOptions{statuses: []string{"ready"}},
+			want:     []string{"web-1/proxy"},
+		},
+		{
+			name:     "A pod pattern can be a regular expression",
+			patterns: []string{".*-1$"},
+			opts:     ContainersOptions{statuses: []string{"waiting", "terminated"}},
+			want:     []string{"api-1/migrate", "api-1/api", "web-1/migrate", "web-1/api"},
+		},
+		{
+			name:     "Not ready should leave out ready and completed init containers",
+			patterns: []string{"api"},
+			opts:     ContainersOptions{notReady: true},
+			want:     []string{"api-1/api", "api-1/debugger"},
+		},
+		{
+			name: "An image glob should match across slashes",
+			opts: ContainersOptions{image: "*nginx*"},
+			want: []string{"web-1/api"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns, err := compilePodPatterns(tt.patterns)
+			if err != nil {
+				t.Fatalf("compilePodPatterns() error = %v", err)
+			}
+			got := []string{}
+			for _, container := range filterContainers(collectContainers(filterPods(pods, patterns)), tt.opts) {
+				got = append(got, container.Pod+"/"+container.Container)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterContainers() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_listContainersInContexts(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{}
+	for _, name := range []string{"prod-eu", "prod-us"} {
+		path := filepath.Join(dir, name)
+		writeKubeconfig(t, path, "", name, "default")
+		paths = append(paths, path)
+	}
+	t.Setenv("KUBECONFIG", strings.Join(paths, string(os.PathListSeparator)))
+
+	// The clusters refuse connections, so neither can be reached
+	opts := ContainersOptions{timeout: time.Second}
+	got := listContainersInContexts(KubeConnection{}, []string{"prod-us", "prod-eu"}, "", metav1.ListOptions{}, nil, opts)
+	contexts := []string{}
+	for _, container := range got {
+		if container.Status != "unreachable" || container.Error == "" {
+			t.Errorf("listContainersInContexts() got: %+v, want an unreachable row", container)
+		}
+		contexts = append(contexts, container.Context)
+	}
+	if want := []string{"prod-us", "prod-eu"}; !reflect.DeepEqual(contexts, want) {
+		t.Errorf("listContainersInContexts() contexts got: %q, want: %q", contexts, want)
+	}
+
+	var buf bytes.Buffer
+	columns, _ := selectContainerColumns([]string{"context", "pod", "reason"})
+	writeContainers(&buf, "name", got, columns, false, false)
+	if buf.String() != "" {
+		t.Errorf("writeContainers() name output got: %q, want no rows for unreachable contexts", buf.String())
+	}
+}
@@ -0,0 +1,250 @@
+package koi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// How often the table is redrawn at most, rollouts change a lot of pods at once
+const containersRedrawInterval = 500 * time.Millisecond
+
+// containerTransition is a container changing status, eg: waiting to running
+type containerTransition struct {
+	time time.Time
+	from string
+	to   string
+}
+
+// containersWatcher keeps the latest state of the watched pods and the transitions of their containers
+type containersWatcher struct {
+	mu          sync.Mutex
+	opts        ContainersOptions
+	podPatterns []*regexp.Regexp
+	now         func() time.Time
+	// synced is set once the informer has listed the existing pods, transitions are only reported after that
+	synced  bool
+	dirty   bool
+	pods    map[string]v1.Pod
+	status  map[string]string
+	changes map[string]containerTransition
+	// shown holds the containers which pass the filters, eg: --not-ready, a container leaving them is still reported
+	shown map[string]bool
+}
+
+func newContainersWatcher(opts ContainersOptions, podPatterns []*regexp.Regexp) *containersWatcher {
+	return &containersWatcher{
+		opts:        opts,
+		podPatterns: podPatterns,
+		now:         time.Now,
+		pods:        map[string]v1.Pod{},
+		status:      map[string]string{},
+		changes:     map[string]containerTransition{},
+		shown:       map[string]bool{},
+	}
+}
+
+func containerKey(c ContainerInfo) string {
+	return c.Namespace + "/" + c.Pod + "/" + c.Container
+}
+
+// update records the latest version of a pod, or its deletion, and returns a line per container which changed
+func (w *containersWatcher) update(pod *v1.Pod, deleted bool) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	podKey := pod.GetNamespace() + "/" + pod.GetName()
+	if deleted {
+		delete(w.pods, podKey)
+	} else {
+		w.pods[podKey] = *pod
+	}
+	w.dirty = true
+
+	// Every container is diffed, the filters only pick what is printed, so the from state is right when one comes back
+	containers := collectContainers(filterPods([]v1.Pod{*pod}, w.podPatterns))
+	passing := map[string]bool{}
+	for _, container := range filterContainers(containers, w.opts) {
+		passing[containerKey(container)] = true
+	}
+
+	now := w.now()
+	lines := []string{}
+	for _, container := range containers {
+		key := containerKey(container)
+		wasShown := w.shown[key]
+		if deleted {
+			delete(w.shown, key)
+		} else {
+			w.shown[key] = passing[key]
+		}
+
+		to := container.Status
+		if deleted {
+			to = "deleted"
+		}
+		from, seen := w.status[key]
+		if seen && from == to {
+			continue
+		}
+		if deleted {
+			delete(w.status, key)
+		} else {
+			w.status[key] = to
+		}
+		if !w.synced || (!wasShown && !passing[key]) {
+			continue
+		}
+
+		from = coalesceString(from, "new")
+		w.changes[key] = containerTransition{time: now, from: from, to: to}
+		line := fmt.Sprintf("%s %s %s → %s", now.Local().Format(time.TimeOnly), key, from, to)
+		if reason := withExitCode(container.Reason, container.ExitCode); reason != "" && !deleted {
+			line += " (" + reason + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// containers returns the containers of every pod being watched, sorted like a fresh listing
+func (w *containersWatcher) containers() []ContainerInfo {
+	keys := make([]string, 0, len(w.pods))
+	for key := range w.pods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pods := make([]v1.Pod, 0, len(keys))
+	for _, key := range keys {
+		pods = append(pods, w.pods[key])
+	}
+	return filterContainers(collectContainers(filterPods(pods, w.podPatterns)), w.opts)
+}
+
+// changedColumn shows the last transition of each container while watching
+func (w *containersWatcher) changedColumn() containerColumn {
+	return containerColumn{"changed", "Changed", func(c ContainerInfo, now time.Time) string {
+		change, ok := w.changes[containerKey(c)]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf("%s → %s %s", change.from, change.to, change.time.Local().Format(time.TimeOnly))
+	}}
+}
+
+// redraw clears the terminal and prints the table if anything changed since the last redraw
+func (w *containersWatcher) redraw(out io.Writer, columns []containerColumn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return
+	}
+	w.dirty = false
+
+	now := w.now()
+	fmt.Fprint(out, "\x1b[H\x1b[2J")
+	fmt.Fprintf(out, "Watching containers, updated %s. Ctrl-C to stop.\n\n", now.Local().Format(time.TimeOnly))
+	printContainersTable(out, w.containers(), append(columns, w.changedColumn()), w.opts.writeInColor, now)
+}
+
+// watchContainers keeps the containers up to date with an informer until Ctrl-C
+// On a terminal the table is redrawn in place, otherwise a line is written for each change
+func watchContainers(conn KubeConnection, opts ContainersOptions, namespace string, listOptions metav1.ListOptions, podPatterns []*regexp.Regexp, columns []containerColumn) (exitCode int, runError error) {
+	if opts.output != "" && opts.output != "wide" {
+		return 1, fmt.Errorf("--watch can only be used with the table output")
+	}
+
+	client, err := getKubeClient(conn)
+	if err != nil {
+		return 1, errors.Wrap(err, "getting kube client")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = listOptions.LabelSelector
+			o.FieldSelector = listOptions.FieldSelector
+		}),
+	)
+	informer := factory.Core().V1().Pods().Informer()
+
+	interactive := WritingToTerminal()
+	watcher := newContainersWatcher(opts, podPatterns)
+	report := func(lines []string) {
+		if interactive {
+			return
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				report(watcher.update(pod, false))
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if pod, ok := newObj.(*v1.Pod); ok {
+				report(watcher.update(pod, false))
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*v1.Pod); ok {
+				report(watcher.update(pod, true))
+			}
+		},
+	})
+	if err != nil {
+		return 1, errors.Wrap(err, "watching pods")
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return 0, nil
+	}
+
+	// Print the starting point before any change is reported
+	watcher.mu.Lock()
+	watcher.synced = true
+	if !interactive {
+		printContainersTable(os.Stdout, watcher.containers(), columns, false, watcher.now())
+	}
+	watcher.mu.Unlock()
+
+	if !interactive {
+		<-ctx.Done()
+		return 0, nil
+	}
+
+	ticker := time.NewTicker(containersRedrawInterval)
+	defer ticker.Stop()
+	for {
+		watcher.redraw(os.Stdout, columns)
+		select {
+		case <-ctx.Done():
+			return 0, nil
+		case <-ticker.C:
+		}
+	}
+}
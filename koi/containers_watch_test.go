@@ -0,0 +1,129 @@
+package koi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func watchedPod(state v1.ContainerState, ready bool) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "payments"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "api", Image: "api:1.2"}}},
+		Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+			{Name: "api", Ready: ready, State: state},
+		}},
+	}
+}
+
+func Test_containersWatcher_update(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	waiting := v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+	running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+
+	watcher := newContainersWatcher(ContainersOptions{}, nil)
+	watcher.now = func() time.Time { return now }
+
+	steps := []struct {
+		name    string
+		pod     *v1.Pod
+		deleted bool
+		synced  bool
+		want    []string
+	}{
+		{
+			name: "Pods listed before the sync should not be reported",
+			pod:  watchedPod(waiting, false),
+			want: []string{},
+		},
+		{
+			name:   "An update without a status change should not be reported",
+			pod:    watchedPod(waiting, false),
+			synced: true,
+			want:   []string{},
+		},
+		{
+			name:   "Waiting to running should be reported",
+			pod:    watchedPod(running, false),
+			synced: true,
+			want:   []string{"12:00:00 payments/api-1/api waiting → running"},
+		},
+		{
+			name:   "Running to ready should be reported",
+			pod:    watchedPod(running, true),
+			synced: true,
+			want:   []string{"12:00:00 payments/api-1/api running → ready"},
+		},
+		{
+			name:    "A deleted pod should be reported",
+			pod:     watchedPod(running, true),
+			deleted: true,
+			synced:  true,
+			want:    []string{"12:00:00 payments/api-1/api ready → deleted"},
+		},
+		{
+			name:   "A new pod after the sync should be reported",
+			pod:    watchedPod(waiting, false),
+			synced: true,
+			want:   []string{"12:00:00 payments/api-1/api new → waiting (ContainerCreating)"},
+		},
+	}
+	for _, step := range steps {
+		watcher.synced = step.synced
+		got := watcher.update(step.pod, step.deleted)
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: containersWatcher.update() got: %q, want: %q", step.name, got, step.want)
+		}
+	}
+
+	if got := len(watcher.containers()); got != 1 {
+		t.Errorf("containersWatcher.containers() got: %v containers, want: 1", got)
+	}
+}
+
+func Test_containersWatcher_update_notReady(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	waiting := v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+	running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+
+	watcher := newContainersWatcher(ContainersOptions{notReady: true}, nil)
+	watcher.now = func() time.Time { return now }
+	watcher.update(watchedPod(waiting, false), false)
+	watcher.synced = true
+
+	steps := []struct {
+		name string
+		pod  *v1.Pod
+		want []string
+	}{
+		{
+			name: "A change of a container which is not ready should be reported",
+			pod:  watchedPod(running, false),
+			want: []string{"12:00:00 payments/api-1/api waiting → running"},
+		},
+		{
+			name: "Becoming ready should be reported though the container leaves --not-ready",
+			pod:  watchedPod(running, true),
+			want: []string{"12:00:00 payments/api-1/api running → ready"},
+		},
+		{
+			name: "A ready container should then be left out",
+			pod:  watchedPod(v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: metav1.Now()}}, true),
+			want: []string{},
+		},
+		{
+			name: "Coming back into --not-ready should be reported from the state it was in",
+			pod:  watchedPod(running, false),
+			want: []string{"12:00:00 payments/api-1/api ready → running"},
+		},
+	}
+	for _, step := range steps {
+		got := watcher.update(step.pod, false)
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: containersWatcher.update() got: %q, want: %q", step.name, got, step.want)
+		}
+	}
+}
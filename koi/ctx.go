@@ -0,0 +1,205 @@
+package koi
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+// The namespace list comes from the cluster, so give slow clusters a moment
+const namespaceListTimeout = 10 * time.Second
+
+type SwitcherOptions struct {
+	current bool
+	unset   bool
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "ctx",
+		Summary: "Pick the context for this terminal, without changing the kubeconfig",
+		Usage:   "koi ctx [NAME|-]",
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			opts := SwitcherOptions{}
+			f.BoolVarP(&opts.current, "current", "c", false, "Print the context this terminal is using")
+			f.BoolVarP(&opts.unset, "unset", "u", false, "Stop using a context for this terminal")
+			return func(inv Invocation) (int, error) {
+				return ContextCommand(inv.Settings, inv.Connection, opts, inv.Args)
+			}
+		},
+		PassUnknownFlags: true,
+		Complete: func(settings Settings, args []string, toComplete string) []string {
+			contexts, _ := listKubeContexts(KubeConnection{})
+			return contexts
+		},
+	})
+
+	RegisterCommand(&Command{
+		Name:    "ns",
+		Summary: "Pick the namespace for this terminal, without changing the kubeconfig",
+		Usage:   "koi ns [NAME|-]",
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			opts := SwitcherOptions{}
+			f.BoolVarP(&opts.current, "current", "c", false, "Print the namespace this terminal is using")
+			f.BoolVarP(&opts.unset, "unset", "u", false, "Stop using a namespace for this terminal")
+			return func(inv Invocation) (int, error) {
+				return NamespaceCommand(inv.Settings, inv.Connection, opts, inv.Args)
+			}
+		},
+		PassUnknownFlags: true,
+		Complete: func(settings Settings, args []string, toComplete string) []string {
+			namespaces, _ := listNamespaces(KubeConnection{Context: settings.Context}, completionTimeout)
+			return namespaces
+		},
+	})
+}
+
+// currentContext is the context koi commands use: the settings, then the kubeconfig
+func currentContext(settings Settings, conn KubeConnection) (string, error) {
+	conn.Context = settings.Context
+	return kubeconfigCurrentContext(conn)
+}
+
+func ContextCommand(settings Settings, conn KubeConnection, opts SwitcherOptions, args []string) (exitCode int, runError error) {
+	current, err := currentContext(settings, conn)
+	if err != nil {
+		return 1, err
+	}
+	if opts.current {
+		fmt.Println(current)
+		return 0, nil
+	}
+
+	state, err := LoadSessionState()
+	if err != nil {
+		return 1, err
+	}
+	if opts.unset {
+		state.PreviousContext = state.Context
+		state.Context = ""
+		state.Namespace = ""
+		return 0, SaveSessionState(state)
+	}
+
+	contexts, err := listKubeContexts(conn)
+	if err != nil {
+		return 1, err
+	}
+
+	picked, err := pickSwitcherValue("context", args, contexts, current, state.PreviousContext)
+	if err == errPickerAborted {
+		return 1, nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	if picked == "" {
+		return 0, nil
+	}
+
+	if picked != current {
+		state.PreviousContext = current
+		state.PreviousNamespace = state.Namespace
+		// The namespace probably doesn't exist in the new cluster
+		state.Namespace = ""
+	}
+	state.Context = picked
+	err = SaveSessionState(state)
+	if err != nil {
+		return 1, err
+	}
+	fmt.Printf("Switched to context %q in this terminal.\n", picked)
+	return 0, nil
+}
+
+func NamespaceCommand(settings Settings, conn KubeConnection, opts SwitcherOptions, args []string) (exitCode int, runError error) {
+	kubeContext, err := currentContext(settings, conn)
+	if err != nil {
+		return 1, err
+	}
+	conn.Context = kubeContext
+	current := settings.Namespace
+	if current == "" {
+		current, err = kubeconfigNamespace(conn)
+		if err != nil {
+			return 1, err
+		}
+	}
+	if opts.current {
+		fmt.Println(current)
+		return 0, nil
+	}
+
+	state, err := LoadSessionState()
+	if err != nil {
+		return 1, err
+	}
+	if opts.unset {
+		state.PreviousNamespace = state.Namespace
+		state.Namespace = ""
+		return 0, SaveSessionState(state)
+	}
+
+	var namespaces []string
+	if len(args) == 0 || args[0] != "-" {
+		namespaces, err = listNamespaces(conn, namespaceListTimeout)
+		if err != nil {
+			return 1, errors.Wrapf(err, "in context %q", kubeContext)
+		}
+	}
+
+	picked, err := pickSwitcherValue("namespace", args, namespaces, current, state.PreviousNamespace)
+	if err == errPickerAborted {
+		return 1, nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	if picked == "" {
+		return 0, nil
+	}
+
+	if picked != current {
+		state.PreviousNamespace = current
+	}
+	state.Namespace = picked
+	err = SaveSessionState(state)
+	if err != nil {
+		return 1, err
+	}
+	fmt.Printf("Switched to namespace %q in context %q in this terminal.\n", picked, kubeContext)
+	return 0, nil
+}
+
+// pickSwitcherValue returns the value named in args, the previous value for "-", or asks the user to pick one
+// Without a terminal to pick on, the choices are listed instead and nothing is picked
+func pickSwitcherValue(kind string, args []string, choices []string, current string, previous string) (string, error) {
+	if len(args) > 0 {
+		if args[0] == "-" {
+			if previous == "" {
+				return "", fmt.Errorf("there is no previous %s in this terminal", kind)
+			}
+			return previous, nil
+		}
+		if choices != nil && !stringArrayContains(choices, args[0]) {
+			return "", fmt.Errorf("%s %q does not exist", kind, args[0])
+		}
+		return args[0], nil
+	}
+
+	if !WritingToTerminal() || !term.IsTerminal(int(os.Stdin.Fd())) {
+		for _, choice := range choices {
+			marker := " "
+			if choice == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, choice)
+		}
+		return "", nil
+	}
+	return pickInteractively(kind, choices, current)
+}
@@ -1,35 +1,452 @@
 package koi
 
-import "regexp"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
-func EventsCommand(exe string, args []string) (exitCode int, runError error) {
-	cmdArg := []string{
-		"get",
-		"events",
-		"--sort-by=.metadata.creationTimestamp",
-	}
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/rodaine/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+type EventsOptions struct {
+	namespace     string
+	output        string
+	context       string
+	allNamespaces bool
+	watch         bool
+	forObject     string
+	reason        string
+	eventType     string
+	since         time.Duration
+	includeNormal bool
+	group         bool
+	writeInColor  bool
+}
 
-	cmdArg = copyArgsIntoNewArgs(args,
-		cmdArg,
-		[][]string{
-			{"-n", "--namespace"},
-			{"-o", "--output"},
-			{"--context"},
+func init() {
+	RegisterCommand(&Command{
+		Name:             "events",
+		Summary:          "Show events sorted by time, hiding Normal events",
+		Usage:            "koi events [flags]",
+		PassUnknownFlags: true,
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			opts := EventsOptions{}
+			f.StringVarP(&opts.namespace, "namespace", "n", "", "Namespace to get events in")
+			f.StringVarP(&opts.output, "output", "o", "", "Output format: json or yaml for events grouped by object, anything else is printed by kubectl")
+			f.StringVar(&opts.context, "context", "", "Context to get events in")
+			f.BoolVarP(&opts.allNamespaces, "all-namespaces", "A", false, "Get events in all namespaces")
+			f.BoolVarP(&opts.watch, "watch", "w", false, "Keep watching for new events")
+			f.StringVar(&opts.forObject, "for", "", "Only show events for this object, eg: pod/api-1")
+			f.StringVar(&opts.reason, "reason", "", "Only show events with this reason, eg: BackOff")
+			f.StringVar(&opts.eventType, "type", "", "Only show events of this type: Normal or Warning")
+			f.DurationVar(&opts.since, "since", 0, "Only show events seen within this duration, eg: 10m")
+			f.BoolVar(&opts.includeNormal, "include-normal", false, "Show Normal events too")
+			f.BoolVar(&opts.group, "group", true, "Show a section per object, with the objects it owns below it, eg: Deployment, ReplicaSet, Pod, --group=false for a row per event")
+			f.BoolVar(&opts.writeInColor, "color", WritingToTerminal(), "Configure color output")
+			return func(inv Invocation) (int, error) {
+				return EventsCommand(inv, opts)
+			}
 		},
-		[][]string{
-			{"--all-namespaces"},
+	})
+}
+
+func EventsCommand(inv Invocation, opts EventsOptions) (exitCode int, runError error) {
+	fieldSelector, err := eventFieldSelector(opts)
+	if err != nil {
+		return 1, err
+	}
+
+	structured := opts.output == "json" || opts.output == "yaml"
+
+	// kubectl knows how to print every other output format, so hand those over
+	if opts.output != "" && !structured {
+		if opts.since != 0 {
+			return 1, fmt.Errorf("--since cannot be used with --output")
+		}
+		cmdArg := []string{"get", "events"}
+		if opts.watch {
+			cmdArg = append(cmdArg, "--watch")
+		} else {
+			cmdArg = append(cmdArg, "--sort-by=.metadata.creationTimestamp")
+		}
+		if fieldSelector != "" {
+			cmdArg = append(cmdArg, "--field-selector", fieldSelector)
+		}
+		if opts.namespace != "" {
+			cmdArg = append(cmdArg, "--namespace", opts.namespace)
+		}
+		cmdArg = append(cmdArg, "--output", opts.output)
+		if opts.context != "" {
+			cmdArg = append(cmdArg, "--context", opts.context)
+		}
+		if opts.allNamespaces {
+			cmdArg = append(cmdArg, "--all-namespaces")
+		}
+		return runCommandAndFilterOutput(inv.Settings.KubectlExe, cmdArg)
+	}
+
+	conn := inv.Connection
+	conn.Context = opts.context
+	client, err := getKubeClient(conn)
+	if err != nil {
+		return 1, errors.Wrap(err, "getting kube client")
+	}
+
+	namespace := opts.namespace
+	if opts.allNamespaces {
+		namespace = ""
+	} else if namespace == "" {
+		namespace, err = kubeconfigNamespace(conn)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listOptions := metav1.ListOptions{FieldSelector: fieldSelector}
+	eventList, err := client.CoreV1().Events(namespace).List(ctx, listOptions)
+	if err != nil {
+		return 1, fmt.Errorf("listing events: %w", err)
+	}
+
+	printer := eventPrinter{
+		opts:          opts,
+		showNamespace: namespace == "",
+		now:           time.Now,
+		aggregator:    newEventAggregator(),
+	}
+
+	events := eventList.Items
+	sort.SliceStable(events, func(i, j int) bool { return eventTime(&events[i]).Before(eventTime(&events[j])) })
+	for i := range events {
+		printer.add(&events[i])
+	}
+
+	resolver := newOwnerResolver(client)
+	switch {
+	case structured:
+		roots := groupEventsByOwner(ctx, resolver, printer.aggregator.order)
+		err = writeStructured(os.Stdout, opts.output, roots)
+		if err != nil {
+			return 1, err
+		}
+	case opts.group:
+		printer.printGroupedEvents(os.Stdout, groupEventsByOwner(ctx, resolver, printer.aggregator.order))
+	default:
+		printer.printTable(os.Stdout)
+	}
+
+	if !opts.watch {
+		return 0, nil
+	}
+
+	watcher, err := watchtools.NewRetryWatcherWithContext(ctx, eventList.ResourceVersion, &cache.ListWatch{
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return client.CoreV1().Events(namespace).Watch(ctx, options)
 		},
-	)
+	})
+	if err != nil {
+		return 1, fmt.Errorf("watching events: %w", err)
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, nil
+		case ev, ok := <-watcher.ResultChan():
+			if !ok {
+				return 0, nil
+			}
+			switch ev.Type {
+			case watch.Added, watch.Modified:
+				event, ok := ev.Object.(*v1.Event)
+				if !ok {
+					continue
+				}
+				group := printer.add(event)
+				if group == nil {
+					continue
+				}
+				// Each change is written as the same tree of owners as the list, holding only the changed events
+				switch {
+				case structured:
+					err = writeStructured(os.Stdout, opts.output, groupEventsByOwner(ctx, resolver, []*eventGroup{group}))
+					if err != nil {
+						return 1, err
+					}
+				case opts.group:
+					fmt.Fprintln(os.Stdout)
+					printer.printGroupedEvents(os.Stdout, groupEventsByOwner(ctx, resolver, []*eventGroup{group}))
+				default:
+					printer.printLine(os.Stdout, group)
+				}
+			case watch.Error:
+				return 1, fmt.Errorf("watching events: %v", ev.Object)
+			}
+		}
+	}
+}
+
+// writeStructured writes obj as indented json or a yaml document
+func writeStructured(w io.Writer, format string, obj interface{}) error {
+	if format == "yaml" {
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return errors.Wrap(encoder.Encode(obj), "failed to encode yaml")
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(obj), "failed to encode json")
+}
+
+// eventFieldSelector narrows the events on the server, which works for kubectl output too
+// Normal events are left out on the server unless they are asked for
+func eventFieldSelector(opts EventsOptions) (string, error) {
+	selectors := []string{}
+	if opts.forObject != "" {
+		parts := strings.SplitN(opts.forObject, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", fmt.Errorf("--for must be KIND/NAME, eg: pod/api-1, not %q", opts.forObject)
+		}
+		selectors = append(selectors, "involvedObject.kind="+normalizeKind(parts[0]), "involvedObject.name="+parts[1])
+	}
+	if opts.reason != "" {
+		selectors = append(selectors, "reason="+opts.reason)
+	}
+	if opts.eventType != "" {
+		eventType := normalizeEventType(opts.eventType)
+		if eventType != v1.EventTypeNormal && eventType != v1.EventTypeWarning {
+			return "", fmt.Errorf("--type must be Normal or Warning, not %q", opts.eventType)
+		}
+		selectors = append(selectors, "type="+eventType)
+	} else if !opts.includeNormal {
+		selectors = append(selectors, "type!="+v1.EventTypeNormal)
+	}
+	return strings.Join(selectors, ","), nil
+}
+
+// kindAliases maps the short names kubectl accepts to the kind events use
+var kindAliases = map[string]string{
+	"po": "Pod", "pod": "Pod", "pods": "Pod",
+	"deploy": "Deployment", "deployment": "Deployment", "deployments": "Deployment",
+	"rs": "ReplicaSet", "replicaset": "ReplicaSet", "replicasets": "ReplicaSet",
+	"sts": "StatefulSet", "statefulset": "StatefulSet", "statefulsets": "StatefulSet",
+	"ds": "DaemonSet", "daemonset": "DaemonSet", "daemonsets": "DaemonSet",
+	"job": "Job", "jobs": "Job",
+	"cj": "CronJob", "cronjob": "CronJob", "cronjobs": "CronJob",
+	"no": "Node", "node": "Node", "nodes": "Node",
+	"svc": "Service", "service": "Service", "services": "Service",
+	"pvc": "PersistentVolumeClaim", "persistentvolumeclaim": "PersistentVolumeClaim",
+	"pv": "PersistentVolume", "persistentvolume": "PersistentVolume",
+	"hpa": "HorizontalPodAutoscaler", "horizontalpodautoscaler": "HorizontalPodAutoscaler",
+	"ing": "Ingress", "ingress": "Ingress", "ingresses": "Ingress",
+}
+
+func normalizeKind(kind string) string {
+	if k, ok := kindAliases[strings.ToLower(kind)]; ok {
+		return k
+	}
+	return strings.ToUpper(kind[:1]) + kind[1:]
+}
+
+func normalizeEventType(eventType string) string {
+	if eventType == "" {
+		return ""
+	}
+	return strings.ToUpper(eventType[:1]) + strings.ToLower(eventType[1:])
+}
+
+// eventTime is when the event was last seen
+func eventTime(event *v1.Event) time.Time {
+	if event.Series != nil && !event.Series.LastObservedTime.IsZero() {
+		return event.Series.LastObservedTime.Time
+	}
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+func eventCount(event *v1.Event) int32 {
+	if event.Series != nil && event.Series.Count > 0 {
+		return event.Series.Count
+	}
+	if event.Count > 0 {
+		return event.Count
+	}
+	return 1
+}
+
+// eventGroup is every occurrence of one reason on one object
+type eventGroup struct {
+	Namespace string    `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Kind      string    `json:"kind" yaml:"kind"`
+	Name      string    `json:"name" yaml:"name"`
+	Reason    string    `json:"reason" yaml:"reason"`
+	Type      string    `json:"type" yaml:"type"`
+	Message   string    `json:"message" yaml:"message"`
+	FirstSeen time.Time `json:"firstSeen" yaml:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen" yaml:"lastSeen"`
+	Count     int32     `json:"count" yaml:"count"`
+	// counts holds the latest count of each event in the group, events are updated in place as they repeat
+	counts map[string]int32
+}
+
+// object returns the group's object the way kubectl names it, eg: pod/api-1
+func (g *eventGroup) object() string {
+	return strings.ToLower(g.Kind) + "/" + g.Name
+}
+
+// eventAggregator collapses repeated events by involved object and reason
+type eventAggregator struct {
+	groups map[string]*eventGroup
+	order  []*eventGroup
+}
+
+func newEventAggregator() *eventAggregator {
+	return &eventAggregator{groups: map[string]*eventGroup{}}
+}
+
+// add records the event and returns its group, or nil if the event was already counted
+func (a *eventAggregator) add(event *v1.Event) *eventGroup {
+	obj := event.InvolvedObject
+	key := strings.Join([]string{obj.Namespace, obj.Kind, obj.Name, event.Reason}, "/")
+	group, ok := a.groups[key]
+	if !ok {
+		group = &eventGroup{
+			Namespace: coalesceString(obj.Namespace, event.Namespace),
+			Kind:      obj.Kind,
+			Name:      obj.Name,
+			Reason:    event.Reason,
+			FirstSeen: eventTime(event),
+			counts:    map[string]int32{},
+		}
+		a.groups[key] = group
+		a.order = append(a.order, group)
+	}
+
+	count := eventCount(event)
+	eventKey := event.Namespace + "/" + event.Name
+	if previous, seen := group.counts[eventKey]; seen && previous >= count {
+		return nil
+	}
+	group.Count += count - group.counts[eventKey]
+	group.counts[eventKey] = count
 
-	filterRegex := regexp.MustCompile(`\bNormal\b`)
-	filter := func(line string) (string, bool) {
-		return line, !filterRegex.MatchString(line)
+	seen := eventTime(event)
+	if seen.Before(group.FirstSeen) {
+		group.FirstSeen = seen
 	}
+	if !seen.Before(group.LastSeen) {
+		group.LastSeen = seen
+		group.Type = event.Type
+		group.Message = strings.TrimSpace(event.Message)
+	}
+	return group
+}
+
+// eventPrinter filters events which the field selector cannot and prints the groups
+type eventPrinter struct {
+	opts          EventsOptions
+	showNamespace bool
+	now           func() time.Time
+	aggregator    *eventAggregator
+}
+
+// add returns the event's group if it should be printed
+func (p *eventPrinter) add(event *v1.Event) *eventGroup {
+	if event.Type == v1.EventTypeNormal && !p.opts.includeNormal && p.opts.eventType == "" {
+		return nil
+	}
+	if p.opts.since > 0 && eventTime(event).Before(p.now().Add(-p.opts.since)) {
+		return nil
+	}
+	return p.aggregator.add(event)
+}
+
+func (p *eventPrinter) colorType(eventType string) string {
+	if !p.opts.writeInColor {
+		return eventType
+	}
+	if eventType == v1.EventTypeWarning {
+		return color.YellowString("%s", eventType)
+	}
+	return color.GreenString("%s", eventType)
+}
+
+func (p *eventPrinter) titleColor(title string) string {
+	return color.New(color.FgHiWhite, color.Bold).Sprint(title)
+}
 
-	// If the output is set, then we don't want to do any filtering
-	if val := extractValueArgumentFromArgs(cmdArg, "-o", "--output"); val != "" {
-		filter = nil
+func (p *eventPrinter) printTable(w io.Writer) {
+	columns := []interface{}{"Last Seen", "Type", "Reason", "Object", "Count", "Message"}
+	if p.showNamespace {
+		columns = append([]interface{}{"Namespace"}, columns...)
 	}
+	tbl := table.New(columns...).WithWriter(w)
 
-	return runCommandAndFilterOutput(exe, cmdArg, filter)
+	groups := append([]*eventGroup{}, p.aggregator.order...)
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].LastSeen.Before(groups[j].LastSeen) })
+	for _, group := range groups {
+		row := []interface{}{
+			duration.HumanDuration(p.now().Sub(group.LastSeen)),
+			p.colorType(group.Type),
+			group.Reason,
+			group.object(),
+			group.Count,
+			group.Message,
+		}
+		if p.showNamespace {
+			row = append([]interface{}{group.Namespace}, row...)
+		}
+		tbl.AddRow(row...)
+	}
+
+	if p.opts.writeInColor {
+		tbl.WithHeaderFormatter(color.New(color.FgHiWhite, color.Underline).SprintfFunc())
+	}
+	if len(groups) == 0 {
+		log.Info("No events found")
+		return
+	}
+	tbl.Print()
+}
+
+// printLine writes a group as it changes while watching
+func (p *eventPrinter) printLine(w io.Writer, group *eventGroup) {
+	object := group.object()
+	if p.showNamespace {
+		object = group.Namespace + "/" + object
+	}
+	count := ""
+	if group.Count > 1 {
+		count = fmt.Sprintf(" (x%d)", group.Count)
+	}
+	fmt.Fprintf(w, "%s %s %s %s%s: %s\n", group.LastSeen.Local().Format(time.TimeOnly), p.colorType(group.Type), group.Reason, object, count, group.Message)
 }
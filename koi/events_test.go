@@ -0,0 +1,177 @@
+package koi
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_eventFieldSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    EventsOptions
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "No filters should hide Normal events",
+			opts: EventsOptions{},
+			want: "type!=Normal",
+		},
+		{
+			name: "--include-normal should have no selector",
+			opts: EventsOptions{includeNormal: true},
+			want: "",
+		},
+		{
+			name: "A short kind should become the full kind",
+			opts: EventsOptions{forObject: "deploy/api"},
+			want: "involvedObject.kind=Deployment,involvedObject.name=api,type!=Normal",
+		},
+		{
+			name: "Reason and type should be combined",
+			opts: EventsOptions{reason: "BackOff", eventType: "warning"},
+			want: "reason=BackOff,type=Warning",
+		},
+		{
+			name: "An unknown kind should be capitalized",
+			opts: EventsOptions{forObject: "certificate/web", includeNormal: true},
+			want: "involvedObject.kind=Certificate,involvedObject.name=web",
+		},
+		{
+			name:    "--for without a name should be an error",
+			opts:    EventsOptions{forObject: "pod"},
+			wantErr: true,
+		},
+		{
+			name:    "An unknown type should be an error",
+			opts:    EventsOptions{eventType: "Error"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eventFieldSelector(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("eventFieldSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("eventFieldSelector() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testEvent(name string, pod string, reason string, eventType string, count int32, minutesAgo int) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "koi"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: pod, Namespace: "koi"},
+		Reason:         reason,
+		Type:           eventType,
+		Message:        reason + " happened",
+		Count:          count,
+		LastTimestamp:  metav1.NewTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Add(-time.Duration(minutesAgo) * time.Minute)),
+	}
+}
+
+func Test_eventPrinter_add(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		opts       EventsOptions
+		events     []*v1.Event
+		wantPrints int
+		wantGroups int
+		wantCount  int32
+	}{
+		{
+			name: "An event updated in place should only add the new occurrences",
+			events: []*v1.Event{
+				testEvent("a", "api-1", "BackOff", "Warning", 3, 5),
+				testEvent("a", "api-1", "BackOff", "Warning", 5, 1),
+			},
+			wantPrints: 2,
+			wantGroups: 1,
+			wantCount:  5,
+		},
+		{
+			name: "Separate events with the same object and reason should be collapsed",
+			events: []*v1.Event{
+				testEvent("a", "api-1", "BackOff", "Warning", 2, 5),
+				testEvent("b", "api-1", "BackOff", "Warning", 1, 1),
+			},
+			wantPrints: 2,
+			wantGroups: 1,
+			wantCount:  3,
+		},
+		{
+			name: "An event seen again without a new count should not print",
+			events: []*v1.Event{
+				testEvent("a", "api-1", "BackOff", "Warning", 2, 5),
+				testEvent("a", "api-1", "BackOff", "Warning", 2, 5),
+			},
+			wantPrints: 1,
+			wantGroups: 1,
+			wantCount:  2,
+		},
+		{
+			name: "Normal events should be hidden by default",
+			events: []*v1.Event{
+				testEvent("a", "api-1", "Pulled", "Normal", 1, 5),
+				testEvent("b", "api-1", "BackOff", "Warning", 1, 1),
+			},
+			wantPrints: 1,
+			wantGroups: 1,
+			wantCount:  1,
+		},
+		{
+			name: "Normal events should be shown with --include-normal",
+			opts: EventsOptions{includeNormal: true},
+			events: []*v1.Event{
+				testEvent("a", "api-1", "Pulled", "Normal", 1, 5),
+				testEvent("b", "api-1", "BackOff", "Warning", 1, 1),
+			},
+			wantPrints: 2,
+			wantGroups: 2,
+			wantCount:  1,
+		},
+		{
+			name: "--since should drop older events",
+			opts: EventsOptions{since: 10 * time.Minute},
+			events: []*v1.Event{
+				testEvent("a", "api-1", "BackOff", "Warning", 1, 30),
+				testEvent("b", "api-2", "BackOff", "Warning", 1, 1),
+			},
+			wantPrints: 1,
+			wantGroups: 1,
+			wantCount:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := eventPrinter{
+				opts:       tt.opts,
+				now:        func() time.Time { return now },
+				aggregator: newEventAggregator(),
+			}
+			prints := 0
+			for _, event := range tt.events {
+				if p.add(event) != nil {
+					prints++
+				}
+			}
+			if prints != tt.wantPrints {
+				t.Errorf("eventPrinter.add() prints got: %v, want: %v", prints, tt.wantPrints)
+			}
+			if len(p.aggregator.order) != tt.wantGroups {
+				t.Fatalf("eventPrinter.add() groups got: %v, want: %v", len(p.aggregator.order), tt.wantGroups)
+			}
+			last := p.aggregator.order[len(p.aggregator.order)-1]
+			if last.Count != tt.wantCount {
+				t.Errorf("eventPrinter.add() count got: %v, want: %v", last.Count, tt.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package koi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventObject is an object with its events, and the objects it owns which have events
+type eventObject struct {
+	Kind      string         `json:"kind" yaml:"kind"`
+	Name      string         `json:"name" yaml:"name"`
+	Namespace string         `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Events    []*eventGroup  `json:"events,omitempty" yaml:"events,omitempty"`
+	Owned     []*eventObject `json:"owned,omitempty" yaml:"owned,omitempty"`
+}
+
+// lastSeen is the latest event of the object or anything it owns
+func (o *eventObject) lastSeen() time.Time {
+	latest := time.Time{}
+	for _, group := range o.Events {
+		if group.LastSeen.After(latest) {
+			latest = group.LastSeen
+		}
+	}
+	for _, owned := range o.Owned {
+		if seen := owned.lastSeen(); seen.After(latest) {
+			latest = seen
+		}
+	}
+	return latest
+}
+
+// ownerResolver finds the controller of an object, eg: the ReplicaSet of a Pod
+type ownerResolver struct {
+	client kubernetes.Interface
+	cache  map[string]*metav1.OwnerReference
+}
+
+func newOwnerResolver(client kubernetes.Interface) *ownerResolver {
+	return &ownerResolver{client: client, cache: map[string]*metav1.OwnerReference{}}
+}
+
+// owner returns the controller of the object, or nil if it has none or cannot be found
+func (r *ownerResolver) owner(ctx context.Context, kind string, namespace string, name string) *metav1.OwnerReference {
+	key := strings.Join([]string{kind, namespace, name}, "/")
+	if ref, ok := r.cache[key]; ok {
+		return ref
+	}
+
+	var obj metav1.Object
+	var err error
+	switch kind {
+	case "Pod":
+		obj, err = r.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "ReplicaSet":
+		obj, err = r.client.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "Deployment":
+		obj, err = r.client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "StatefulSet":
+		obj, err = r.client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "DaemonSet":
+		obj, err = r.client.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "Job":
+		obj, err = r.client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+
+	var ref *metav1.OwnerReference
+	if err != nil {
+		// Objects are often gone by the time their events are read, they are shown on their own
+		log.Debugf("Could not find the owner of %s %s/%s: %v", kind, namespace, name, err)
+	} else if obj != nil {
+		ref = metav1.GetControllerOf(obj)
+	}
+	r.cache[key] = ref
+	return ref
+}
+
+// groupEventsByOwner builds one tree per top-level owner, eg: Deployment -> ReplicaSet -> Pod
+// Trees are in the order of their latest event, events within an object in time order
+func groupEventsByOwner(ctx context.Context, resolver *ownerResolver, groups []*eventGroup) []*eventObject {
+	objects := map[string]*eventObject{}
+	isOwned := map[string]bool{}
+	keys := []string{}
+
+	var getObject func(kind string, namespace string, name string) *eventObject
+	getObject = func(kind string, namespace string, name string) *eventObject {
+		key := strings.Join([]string{kind, namespace, name}, "/")
+		if obj, ok := objects[key]; ok {
+			return obj
+		}
+		obj := &eventObject{Kind: kind, Name: name, Namespace: namespace}
+		objects[key] = obj
+		keys = append(keys, key)
+
+		if ref := resolver.owner(ctx, kind, namespace, name); ref != nil {
+			owner := getObject(ref.Kind, namespace, ref.Name)
+			owner.Owned = append(owner.Owned, obj)
+			isOwned[key] = true
+		}
+		return obj
+	}
+
+	for _, group := range groups {
+		obj := getObject(group.Kind, group.Namespace, group.Name)
+		obj.Events = append(obj.Events, group)
+	}
+
+	roots := []*eventObject{}
+	for _, key := range keys {
+		obj := objects[key]
+		sort.SliceStable(obj.Events, func(i, j int) bool { return obj.Events[i].LastSeen.Before(obj.Events[j].LastSeen) })
+		sort.SliceStable(obj.Owned, func(i, j int) bool { return obj.Owned[i].lastSeen().Before(obj.Owned[j].lastSeen()) })
+		if !isOwned[key] {
+			roots = append(roots, obj)
+		}
+	}
+	sort.SliceStable(roots, func(i, j int) bool { return roots[i].lastSeen().Before(roots[j].lastSeen()) })
+	return roots
+}
+
+// printGroupedEvents writes a section per top-level object with what it owns indented below it
+func (p *eventPrinter) printGroupedEvents(w io.Writer, roots []*eventObject) {
+	var printObject func(obj *eventObject, depth int)
+	printObject = func(obj *eventObject, depth int) {
+		indent := strings.Repeat("  ", depth)
+		title := obj.Kind + " " + obj.Name
+		if depth == 0 && obj.Namespace != "" {
+			title = obj.Kind + " " + obj.Namespace + "/" + obj.Name
+		}
+		if p.opts.writeInColor {
+			title = p.titleColor(title)
+		}
+		fmt.Fprintf(w, "%s%s\n", indent, title)
+		for _, group := range obj.Events {
+			count := ""
+			if group.Count > 1 {
+				count = fmt.Sprintf(" (x%d)", group.Count)
+			}
+			fmt.Fprintf(w, "%s  %s %s %s%s: %s\n", indent, group.LastSeen.Local().Format(time.DateTime), p.colorType(group.Type), group.Reason, count, group.Message)
+		}
+		for _, owned := range obj.Owned {
+			printObject(owned, depth+1)
+		}
+	}
+
+	for i, root := range roots {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printObject(root, 0)
+	}
+}
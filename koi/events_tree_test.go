@@ -0,0 +1,77 @@
+package koi
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func controlledBy(kind string, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func Test_groupEventsByOwner(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "koi"}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "api-7d9f", Namespace: "koi", OwnerReferences: controlledBy("Deployment", "api")}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api-7d9f-abc", Namespace: "koi", OwnerReferences: controlledBy("ReplicaSet", "api-7d9f")}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api-7d9f-def", Namespace: "koi", OwnerReferences: controlledBy("ReplicaSet", "api-7d9f")}},
+	)
+
+	at := func(minute int) time.Time { return time.Date(2024, 5, 1, 12, minute, 0, 0, time.UTC) }
+	groups := []*eventGroup{
+		{Namespace: "koi", Kind: "Pod", Name: "api-7d9f-abc", Reason: "BackOff", LastSeen: at(5)},
+		{Namespace: "koi", Kind: "Node", Name: "node-1", Reason: "NodeNotReady", LastSeen: at(1)},
+		{Namespace: "koi", Kind: "Pod", Name: "api-7d9f-def", Reason: "FailedMount", LastSeen: at(3)},
+		{Namespace: "koi", Kind: "Pod", Name: "api-7d9f-abc", Reason: "Unhealthy", LastSeen: at(2)},
+		{Namespace: "koi", Kind: "Pod", Name: "deleted-pod", Reason: "OOMKilling", LastSeen: at(4)},
+	}
+
+	roots := groupEventsByOwner(context.Background(), newOwnerResolver(client), groups)
+
+	var describe func(obj *eventObject) string
+	describe = func(obj *eventObject) string {
+		parts := []string{obj.Kind + "/" + obj.Name}
+		for _, group := range obj.Events {
+			parts = append(parts, group.Reason)
+		}
+		for _, owned := range obj.Owned {
+			parts = append(parts, "["+describe(owned)+"]")
+		}
+		return strings.Join(parts, " ")
+	}
+	got := []string{}
+	for _, root := range roots {
+		got = append(got, describe(root))
+	}
+
+	want := []string{
+		"Node/node-1 NodeNotReady",
+		"Pod/deleted-pod OOMKilling",
+		"Deployment/api [ReplicaSet/api-7d9f [Pod/api-7d9f-def FailedMount] [Pod/api-7d9f-abc Unhealthy BackOff]]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("groupEventsByOwner() got:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func Test_groupEventsByOwner_oneGroup(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "api-7d9f", Namespace: "koi"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api-7d9f-abc", Namespace: "koi", OwnerReferences: controlledBy("ReplicaSet", "api-7d9f")}},
+	)
+	group := &eventGroup{Namespace: "koi", Kind: "Pod", Name: "api-7d9f-abc", Reason: "BackOff"}
+
+	// A watch writes each change as its own tree, it should have the owners of the changed object like a list does
+	roots := groupEventsByOwner(context.Background(), newOwnerResolver(client), []*eventGroup{group})
+	if len(roots) != 1 || roots[0].Kind != "ReplicaSet" || len(roots[0].Owned) != 1 || len(roots[0].Owned[0].Events) != 1 || roots[0].Owned[0].Events[0] != group {
+		t.Errorf("groupEventsByOwner() should put the group under its owner, got: %+v", roots)
+	}
+}
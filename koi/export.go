@@ -3,12 +3,28 @@ package koi
 import (
 	"fmt"
 	"io"
+	"os"
 	"strconv"
 	"strings"
 
+	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v3"
 )
 
+func init() {
+	RegisterCommand(&Command{
+		Name:             "export",
+		Summary:          "Strip cluster-specific fields from yaml piped in, eg: kubectl get secret foo -o yaml | koi export",
+		Usage:            "koi export < resource.yaml",
+		PassUnknownFlags: true,
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			return func(inv Invocation) (int, error) {
+				return ExportCommand(os.Stdin, os.Stdout)
+			}
+		},
+	})
+}
+
 func ExportCommand(input io.Reader, output io.Writer) (exitCode int, runError error) {
 	inputContent, err := io.ReadAll(input)
 	if err != nil {
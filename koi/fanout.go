@@ -0,0 +1,322 @@
+package koi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// contextAnnotation is added to every object merged from several contexts, so it is clear which cluster it came from
+const contextAnnotation = "koi/context"
+
+// contextColors tell the contexts apart when the prefixed output is written to a terminal
+var contextColors = []color.Attribute{color.FgCyan, color.FgMagenta, color.FgYellow, color.FgGreen, color.FgBlue, color.FgRed}
+
+// contextResult is the outcome of running kubectl in one context
+type contextResult struct {
+	context  string
+	exitCode int
+	// output is kubectl's stdout, it is only kept when the outputs are merged
+	output bytes.Buffer
+}
+
+// ExtractContextsFlag removes --contexts from args and returns its value
+// It is koi's own flag so it must never reach kubectl
+func ExtractContextsFlag(args []string) ([]string, string) {
+	patterns := extractValueArgumentFromArgs(args, "--contexts")
+	return removeValueFlag(args, "--contexts"), patterns
+}
+
+// ResolveContexts returns the kubeconfig contexts matching a comma separated list of names and globs, eg: "prod-*,staging"
+// Every pattern has to match at least one context, so a typo does not quietly skip a cluster
+func ResolveContexts(patterns string, args []string) ([]string, error) {
+	available, err := listKubeContexts(kubeConnectionFromArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return matchContexts(patterns, available)
+}
+
+// matchContexts returns the available contexts matching a comma separated list of names and globs
+func matchContexts(patterns string, available []string) ([]string, error) {
+	contexts := []string{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matched := false
+		for _, kubeContext := range available {
+			if globMatch(pattern, kubeContext) {
+				matched = true
+				if !stringArrayContains(contexts, kubeContext) {
+					contexts = append(contexts, kubeContext)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no context matches %q, available contexts: %q", pattern, available)
+		}
+	}
+	if len(contexts) == 0 {
+		return nil, fmt.Errorf("--contexts needs a context name or glob, eg: --contexts='prod-*'")
+	}
+	return contexts, nil
+}
+
+// argsForContext returns args with their context replaced by kubeContext
+func argsForContext(args []string, kubeContext string) []string {
+	return appendFlagToEnd(removeValueFlag(args, "--context", "-x"), "--context", kubeContext)
+}
+
+// appendFlagToEnd adds the flag after every arg but before any double-dash
+// appendArgument goes before the last arg, which would split a flag from its value once the context is taken out, eg: -n web
+func appendFlagToEnd(args []string, flag ...string) []string {
+	insertionPoint := len(args)
+	for i, a := range args {
+		if a == "--" {
+			insertionPoint = i
+			break
+		}
+	}
+	ret := make([]string, 0, len(args)+len(flag))
+	ret = append(ret, args[:insertionPoint]...)
+	ret = append(ret, flag...)
+	return append(ret, args[insertionPoint:]...)
+}
+
+// CheckGuardrailsInContexts checks the guardrails for each context the command will run in
+func CheckGuardrailsInContexts(settings Settings, args []string, contexts []string, yes bool) error {
+	for _, kubeContext := range contexts {
+		err := CheckGuardrails(settings, "", argsForContext(args, kubeContext), yes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunKubectlInContexts runs kubectl with args in every context at the same time
+// Each line of output is prefixed with its context, except json and yaml which are merged into a single list
+// The exit code is the number of contexts kubectl failed in
+func RunKubectlInContexts(inv Invocation, contexts []string, args []string) (exitCode int, runError error) {
+	if inv.Audit != nil {
+		inv.Audit.Context = strings.Join(contexts, ",")
+	}
+
+	output := extractValueArgumentFromArgs(args, "--output", "-o")
+	merge := inv.Filter != nil || output == "json" || output == "yaml"
+	if output == "yaml" {
+		args = appendFlagToEnd(removeValueFlag(args, "--output", "-o"), "--output=json")
+	}
+
+	// Every kubectl gets its own copy of a manifest piped into `-f -`
+	var stdin []byte
+	if extractValueArgumentFromArgs(args, "--filename", "-f") == "-" {
+		var err error
+		stdin, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return 1, errors.Wrap(err, "failed to read stdin")
+		}
+	}
+
+	width := 0
+	for _, kubeContext := range contexts {
+		width = max(width, len(kubeContext))
+	}
+	writeInColor := WritingToTerminal()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make([]*contextResult, len(contexts))
+	for i, kubeContext := range contexts {
+		result := &contextResult{context: kubeContext}
+		results[i] = result
+
+		prefix := fmt.Sprintf("%-*s  ", width, kubeContext)
+		if writeInColor {
+			prefix = color.New(contextColors[i%len(contextColors)]).Sprint(prefix)
+		}
+		stderr := &prefixWriter{mu: &mu, out: os.Stderr, prefix: prefix}
+		stdout := &prefixWriter{mu: &mu, out: os.Stdout, prefix: prefix}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out io.Writer = stdout
+			if merge {
+				out = &result.output
+			}
+			result.exitCode = runKubectlInContext(inv.Settings.KubectlExe, argsForContext(args, kubeContext), stdin, out, stderr)
+			stdout.Flush()
+			stderr.Flush()
+		}()
+	}
+	wg.Wait()
+
+	if merge {
+		var merged bytes.Buffer
+		err := mergeContextOutputs(results, &merged, os.Stderr)
+		if err != nil {
+			return 1, err
+		}
+		switch {
+		case inv.Filter != nil:
+			err = inv.Filter.Filter(&merged, os.Stdout)
+		case output == "yaml":
+			err = writeJSONAsYAML(&merged, os.Stdout)
+		default:
+			_, err = io.Copy(os.Stdout, &merged)
+		}
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	failed := []string{}
+	for _, result := range results {
+		if result.exitCode != 0 {
+			failed = append(failed, fmt.Sprintf("%s (exit %d)", result.context, result.exitCode))
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "kubectl failed in %d of %d contexts: %s\n", len(failed), len(contexts), strings.Join(failed, ", "))
+	}
+	// Exit codes above 125 mean something else to shells
+	return min(len(failed), 125), nil
+}
+
+func runKubectlInContext(exe string, args []string, stdin []byte, stdout io.Writer, stderr io.Writer) int {
+	cmd := exec.Command(exe, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to run command %q %q: %v\n", exe, args, err)
+		return 1
+	}
+	return 0
+}
+
+// mergeContextOutputs writes the json each context printed as one List, with the context annotated on every item
+// A context whose output cannot be parsed is counted as failed
+func mergeContextOutputs(results []*contextResult, out io.Writer, errOut io.Writer) error {
+	items := []interface{}{}
+	for _, result := range results {
+		decoder := json.NewDecoder(&result.output)
+		decoder.UseNumber()
+		for {
+			var doc map[string]interface{}
+			err := decoder.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fmt.Fprintf(errOut, "%s: failed to parse the json kubectl wrote: %v\n", result.context, err)
+				result.exitCode = max(result.exitCode, 1)
+				break
+			}
+
+			docItems := []interface{}{doc}
+			if listItems, ok := doc["items"].([]interface{}); ok && strings.HasSuffix(fmt.Sprint(doc["kind"]), "List") {
+				docItems = listItems
+			}
+			for _, item := range docItems {
+				if obj, ok := item.(map[string]interface{}); ok {
+					annotateContext(obj, result.context)
+				}
+				items = append(items, item)
+			}
+		}
+	}
+
+	list := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"metadata":   map[string]interface{}{"resourceVersion": ""},
+		"items":      items,
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "    ")
+	encoder.SetEscapeHTML(false)
+	return errors.Wrap(encoder.Encode(list), "failed to encode json")
+}
+
+func annotateContext(obj map[string]interface{}, kubeContext string) {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		obj["metadata"] = metadata
+	}
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = map[string]interface{}{}
+		metadata["annotations"] = annotations
+	}
+	annotations[contextAnnotation] = kubeContext
+}
+
+func writeJSONAsYAML(in io.Reader, out io.Writer) error {
+	decoder := json.NewDecoder(in)
+	decoder.UseNumber()
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse json")
+	}
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	return errors.Wrap(encoder.Encode(doc), "failed to encode yaml")
+}
+
+// prefixWriter writes whole lines to out with a prefix, so lines from commands running at the same time are not mixed up
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Flush writes what is left of an unfinished line
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	io.WriteString(w.out, w.prefix)
+	w.out.Write(line)
+}
@@ -0,0 +1,206 @@
+package koi
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResolveContexts(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{}
+	for _, name := range []string{"prod-eu", "prod-us", "staging"} {
+		path := filepath.Join(dir, name)
+		writeKubeconfig(t, path, "", name, "default")
+		paths = append(paths, path)
+	}
+	t.Setenv("KUBECONFIG", strings.Join(paths, string(os.PathListSeparator)))
+
+	tests := []struct {
+		name     string
+		patterns string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "A glob should match every context it fits",
+			patterns: "prod-*",
+			want:     []string{"prod-eu", "prod-us"},
+		},
+		{
+			name:     "A comma separated list should keep its order and drop repeats",
+			patterns: "staging, prod-*,prod-eu",
+			want:     []string{"staging", "prod-eu", "prod-us"},
+		},
+		{
+			name:     "A pattern which matches nothing should be an error",
+			patterns: "prod-*,dev",
+			wantErr:  true,
+		},
+		{
+			name:     "An empty list should be an error",
+			patterns: ",",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveContexts(tt.patterns, []string{"get", "pods"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveContexts() error: %v, wantErr: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveContexts() got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_argsForContext(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "The context should be added",
+			args: []string{"get", "pods"},
+			want: []string{"get", "pods", "--context", "prod"},
+		},
+		{
+			name: "The default context should be replaced",
+			args: []string{"get", "pods", "--context", "dev", "-n", "web"},
+			want: []string{"get", "pods", "-n", "web", "--context", "prod"},
+		},
+		{
+			name: "The context should go before a double-dash",
+			args: []string{"exec", "api", "--context=dev", "--", "ls", "--context", "x"},
+			want: []string{"exec", "api", "--context", "prod", "--", "ls", "--context", "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := argsForContext(tt.args, "prod"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("argsForContext() got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mergeContextOutputs(t *testing.T) {
+	newResult := func(kubeContext string, output string) *contextResult {
+		result := &contextResult{context: kubeContext}
+		result.output.WriteString(output)
+		return result
+	}
+
+	tests := []struct {
+		name         string
+		results      []*contextResult
+		want         string
+		wantFailures []int
+	}{
+		{
+			name: "Lists and single objects should be merged and annotated",
+			results: []*contextResult{
+				newResult("prod-eu", `{"kind": "PodList", "items": [{"kind": "Pod", "metadata": {"name": "a"}}, {"kind": "Pod", "metadata": {"name": "b", "annotations": {"x": "y"}}}]}`),
+				newResult("prod-us", `{"kind": "Pod", "metadata": {"name": "c"}, "spec": {"replicas": 3}}`),
+			},
+			want: `{
+    "apiVersion": "v1",
+    "items": [
+        {
+            "kind": "Pod",
+            "metadata": {
+                "annotations": {
+                    "koi/context": "prod-eu"
+                },
+                "name": "a"
+            }
+        },
+        {
+            "kind": "Pod",
+            "metadata": {
+                "annotations": {
+                    "koi/context": "prod-eu",
+                    "x": "y"
+                },
+                "name": "b"
+            }
+        },
+        {
+            "kind": "Pod",
+            "metadata": {
+                "annotations": {
+                    "koi/context": "prod-us"
+                },
+                "name": "c"
+            },
+            "spec": {
+                "replicas": 3
+            }
+        }
+    ],
+    "kind": "List",
+    "metadata": {
+        "resourceVersion": ""
+    }
+}
+`,
+			wantFailures: []int{0, 0},
+		},
+		{
+			name: "Output which is not json should fail its context",
+			results: []*contextResult{
+				newResult("prod-eu", `error: the server doesn't have a resource type "pods"`),
+				newResult("prod-us", ``),
+			},
+			want: `{
+    "apiVersion": "v1",
+    "items": [],
+    "kind": "List",
+    "metadata": {
+        "resourceVersion": ""
+    }
+}
+`,
+			wantFailures: []int{1, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			err := mergeContextOutputs(tt.results, &out, &errOut)
+			if err != nil {
+				t.Fatalf("mergeContextOutputs() error: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("mergeContextOutputs() got:\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+			for i, result := range tt.results {
+				if result.exitCode != tt.wantFailures[i] {
+					t.Errorf("mergeContextOutputs() exit code of %s got: %v, want: %v", result.context, result.exitCode, tt.wantFailures[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_prefixWriter(t *testing.T) {
+	var mu sync.Mutex
+	var out bytes.Buffer
+	w := &prefixWriter{mu: &mu, out: &out, prefix: "prod  "}
+
+	w.Write([]byte("NAME   READY\napi-1  1/"))
+	w.Write([]byte("1\nunfinished"))
+	w.Flush()
+
+	want := "prod  NAME   READY\nprod  api-1  1/1\nprod  unfinished\n"
+	if out.String() != want {
+		t.Errorf("prefixWriter got: %q, want: %q", out.String(), want)
+	}
+}
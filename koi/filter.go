@@ -0,0 +1,138 @@
+package koi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/itchyny/gojq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFilter applies a --jq or --yq expression to the json written by kubectl
+// By default the expression is evaluated in-process, so neither jq nor yq need to be installed
+type OutputFilter struct {
+	exe        string
+	expression string
+	external   bool
+	code       *gojq.Code
+}
+
+// NewOutputFilter compiles the expression for the given filter exe ("jq" or "yq")
+// It returns a nil filter when no filter was requested
+// If external is true, the expression is passed to the real jq/yq binary instead
+func NewOutputFilter(exe string, expression string, external bool) (*OutputFilter, error) {
+	if exe == "" {
+		return nil, nil
+	}
+	if exe != "jq" && exe != "yq" {
+		return nil, fmt.Errorf("unknown output filter %q", exe)
+	}
+	if expression == "" {
+		expression = "."
+	}
+
+	filter := &OutputFilter{
+		exe:        exe,
+		expression: expression,
+		external:   external,
+	}
+	if external {
+		return filter, nil
+	}
+
+	query, err := gojq.Parse(expression)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid --%s expression %q", exe, expression)
+	}
+	filter.code, err = gojq.Compile(query, gojq.WithEnvironLoader(os.Environ))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid --%s expression %q", exe, expression)
+	}
+	return filter, nil
+}
+
+// Filter reads a stream of json documents from input and writes the filtered results to output
+func (f *OutputFilter) Filter(input io.Reader, output io.Writer) error {
+	if f.external {
+		return f.runExternal(input, output)
+	}
+
+	decoder := json.NewDecoder(input)
+	decoder.UseNumber()
+	written := 0
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse json for --%s", f.exe)
+		}
+
+		iter := f.code.Run(doc)
+		for {
+			result, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, isErr := result.(error); isErr {
+				return errors.Wrapf(err, "failed to evaluate --%s expression %q", f.exe, f.expression)
+			}
+			if err := f.writeResult(output, result, written); err != nil {
+				return err
+			}
+			written++
+		}
+	}
+}
+
+func (f *OutputFilter) writeResult(output io.Writer, result interface{}, index int) error {
+	var buf bytes.Buffer
+	if f.exe == "yq" {
+		if _, isMap := result.(map[string]interface{}); isMap && index > 0 {
+			buf.WriteString("---\n")
+		}
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(result); err != nil {
+			return errors.Wrap(err, "failed to encode yaml")
+		}
+		encoder.Close()
+	} else if s, isString := result.(string); isString {
+		// Match jq -r, which prints strings without quotes
+		buf.WriteString(s + "\n")
+	} else {
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return errors.Wrap(err, "failed to encode json")
+		}
+	}
+	_, err := output.Write(buf.Bytes())
+	return errors.Wrap(err, "failed to write filter output")
+}
+
+func (f *OutputFilter) runExternal(input io.Reader, output io.Writer) error {
+	filterArgs := []string{}
+	if f.exe == "yq" {
+		filterArgs = append(filterArgs, "-P")
+	} else if f.exe == "jq" {
+		filterArgs = append(filterArgs, "-r")
+	}
+	filterArgs = append(filterArgs, f.expression)
+	log.Debugf("Running external filter: %s %q", f.exe, filterArgs)
+
+	cmd := exec.Command(f.exe, filterArgs...)
+	cmd.Stdin = input
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	return errors.Wrapf(cmd.Run(), "failed to run %s", f.exe)
+}
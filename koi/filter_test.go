@@ -0,0 +1,147 @@
+package koi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewOutputFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		exe        string
+		expression string
+		external   bool
+		wantNil    bool
+		wantErr    bool
+	}{
+		{
+			name:    "No filter exe should return no filter",
+			exe:     "",
+			wantNil: true,
+		},
+		{
+			name:       "Valid jq expression should compile",
+			exe:        "jq",
+			expression: ".items[].metadata.name",
+		},
+		{
+			name:       "Invalid expression should fail before anything is run",
+			exe:        "yq",
+			expression: ".items[",
+			wantErr:    true,
+		},
+		{
+			name:       "Invalid expression is not checked when using the external binary",
+			exe:        "jq",
+			expression: ".items[",
+			external:   true,
+		},
+		{
+			name:       "Unknown filter exe should fail",
+			exe:        "xq",
+			expression: ".",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewOutputFilter(tt.exe, tt.expression, tt.external)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewOutputFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("NewOutputFilter() got: %v, wantNil: %v", got, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestOutputFilter_Filter(t *testing.T) {
+	podList := `{"kind": "List", "items": [{"metadata": {"name": "foo", "uid": 12345678901234567890}}, {"metadata": {"name": "bar"}}]}`
+
+	tests := []struct {
+		name       string
+		exe        string
+		expression string
+		input      string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "jq strings should be printed raw",
+			exe:        "jq",
+			expression: ".items[].metadata.name",
+			input:      podList,
+			want:       "foo\nbar\n",
+		},
+		{
+			name:       "jq objects should be pretty printed",
+			exe:        "jq",
+			expression: ".items[0].metadata",
+			input:      podList,
+			want:       "{\n  \"name\": \"foo\",\n  \"uid\": 12345678901234567890\n}\n",
+		},
+		{
+			name:       "yq should print yaml",
+			exe:        "yq",
+			expression: ".items[1]",
+			input:      podList,
+			want:       "metadata:\n  name: bar\n",
+		},
+		{
+			name:       "yq should separate multiple objects",
+			exe:        "yq",
+			expression: ".items[].metadata | {name}",
+			input:      podList,
+			want:       "name: foo\n---\nname: bar\n",
+		},
+		{
+			name:       "Multiple json documents should each be filtered",
+			exe:        "jq",
+			expression: ".metadata.name",
+			input:      `{"metadata": {"name": "foo"}} {"metadata": {"name": "bar"}}`,
+			want:       "foo\nbar\n",
+		},
+		{
+			name:       "Empty input should not print anything",
+			exe:        "jq",
+			expression: ".",
+			input:      "",
+			want:       "",
+		},
+		{
+			name:       "Runtime errors should be returned",
+			exe:        "jq",
+			expression: ".items + 1",
+			input:      podList,
+			wantErr:    true,
+		},
+		{
+			name:       "Non json input should be an error",
+			exe:        "jq",
+			expression: ".",
+			input:      "NAME   READY",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewOutputFilter(tt.exe, tt.expression, false)
+			if err != nil {
+				t.Fatalf("NewOutputFilter() error = %v", err)
+			}
+			var out bytes.Buffer
+			err = filter.Filter(strings.NewReader(tt.input), &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && out.String() != tt.want {
+				t.Errorf("Filter() got: %q, want: %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -6,9 +6,25 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
 )
 
-func FishCommand(exe string, args []string) (exitCode int, runError error) {
+func init() {
+	RegisterCommand(&Command{
+		Name:             "fish",
+		Summary:          "Print a fish",
+		Usage:            "koi fish",
+		PassUnknownFlags: true,
+		Hidden:           true,
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			return func(inv Invocation) (int, error) {
+				return FishCommand()
+			}
+		},
+	})
+}
+
+func FishCommand() (exitCode int, runError error) {
 	fish := []string{
 		"ICA7LC8vOywgICAgLDsvCiBvOjo6Ojo6Ojs7Ly8vCj46Ojo6Ojo6Ojs7XFxcCiAgJydcXFxcXCciICc7XAo=",
 		"ICAgICAgIC4KICAgICAgIjoiCiAgICBfX186X19fXyAgICAgfCJcLyJ8CiAgLCcgICAgICAgIGAuICAgIFwgIC8KICB8ICBPICAgICAgICBcX19fLyAgfAp+Xn5efl5+Xn5efl5+Xn5efl5+Xn5efl5+Cg==",
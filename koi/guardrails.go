@@ -0,0 +1,178 @@
+package koi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Guardrails make koi ask for confirmation before a mutating command runs against a protected context
+type Guardrails struct {
+	// Contexts are glob patterns of protected contexts, eg: "*prod*"
+	Contexts []string `yaml:"contexts,omitempty"`
+	// Verbs replace the default list of mutating commands, eg: "rollout restart"
+	Verbs []string `yaml:"verbs,omitempty"`
+}
+
+// defaultGuardedVerbs are the commands which change a cluster, koi's own shell included
+var defaultGuardedVerbs = []string{"delete", "apply", "scale", "drain", "edit", "patch", "rollout restart", "shell"}
+
+// ExtractYesFlag removes --yes from args and returns whether it was set
+// It is koi's own flag so it must never reach kubectl
+func ExtractYesFlag(args []string) ([]string, bool) {
+	yes := extractBoolArgumentFromArgs(args, "--yes")
+	ret := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(ret, args[i:]...), yes
+		}
+		if name, _, _ := splitFlagArg(arg); name == "--yes" {
+			continue
+		}
+		ret = append(ret, arg)
+	}
+	return ret, yes
+}
+
+// guardedVerb returns the mutating command args run, or an empty string if they do not change anything
+// commandName is the koi command being run, if any, so `kshell` counts as `shell`
+func guardedVerb(verbs []string, commandName string, args []string) string {
+	if extractBoolArgumentFromArgs(args, "--help", "-h") {
+		return ""
+	}
+	if dryRun := extractValueArgumentFromArgs(args, "--dry-run"); dryRun != "" && dryRun != "none" {
+		return ""
+	}
+
+	words := []string{}
+	rest := args
+	if commandName != "" {
+		words = append(words, commandName)
+	}
+	for len(words) < 2 {
+		word := GetCommand(rest)
+		if word == "" {
+			break
+		}
+		words = append(words, word)
+		rest = RemoveArg(rest, word)
+	}
+
+	for _, verb := range verbs {
+		verbWords := strings.Fields(verb)
+		if len(verbWords) == 0 || len(verbWords) > len(words) {
+			continue
+		}
+		if strings.Join(words[:len(verbWords)], " ") != strings.Join(verbWords, " ") {
+			continue
+		}
+		if words[0] == "shell" && !shellSubcommandChanges(words, args) {
+			continue
+		}
+		return verb
+	}
+	return ""
+}
+
+// shellSubcommandChanges returns true for the shell subcommands which change something:
+// creating a shell, attaching to one, and gc unless it is a dry run
+func shellSubcommandChanges(words []string, args []string) bool {
+	subcommand := ""
+	if len(words) > 1 {
+		subcommand = words[1]
+	}
+	switch subcommand {
+	case "", "attach":
+		return true
+	case "gc":
+		return !extractBoolArgumentFromArgs(args, "--dry-run")
+	default:
+		return false
+	}
+}
+
+// contextIsProtected returns true if the context matches one of the glob patterns
+// The globs match slashes too, so *prod* protects EKS contexts such as arn:aws:eks:us-east-1:123:cluster/prod-eu
+func contextIsProtected(patterns []string, kubeContext string) bool {
+	for _, pattern := range patterns {
+		if globMatch(pattern, kubeContext) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckGuardrails asks the user to type the context name before a mutating command runs against a protected context
+// The confirmation is read from the terminal so piping a manifest into `koi apply -f -` still works
+// An error means the command must not run
+func CheckGuardrails(settings Settings, commandName string, args []string, yes bool) error {
+	if len(settings.Guardrails.Contexts) == 0 {
+		return nil
+	}
+
+	verbs := settings.Guardrails.Verbs
+	if len(verbs) == 0 {
+		verbs = defaultGuardedVerbs
+	}
+	verb := guardedVerb(verbs, commandName, args)
+	if verb == "" {
+		return nil
+	}
+
+	conn := kubeConnectionFromArgs(args)
+	kubeContext, err := kubeconfigCurrentContext(conn)
+	if err != nil {
+		return errors.Wrap(err, "finding the context to check guardrails")
+	}
+	if !contextIsProtected(settings.Guardrails.Contexts, kubeContext) {
+		return nil
+	}
+
+	namespace := extractValueArgumentFromArgs(args, "--namespace", "-n")
+	if namespace == "" {
+		namespace, _ = kubeconfigNamespace(conn)
+	}
+	if extractBoolArgumentFromArgs(args, "--all-namespaces", "-A") {
+		namespace = "all namespaces"
+	}
+
+	printGuardrailBanner(os.Stderr, verb, kubeContext, namespace)
+	if yes {
+		log.Debug("Guardrails confirmed with --yes")
+		return nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("%q is a protected context and there is no terminal to confirm on, pass --yes to run anyway", kubeContext)
+	}
+	defer tty.Close()
+	return confirmContext(tty, tty, kubeContext)
+}
+
+func printGuardrailBanner(w io.Writer, verb string, kubeContext string, namespace string) {
+	banner := color.New(color.BgRed, color.FgHiWhite, color.Bold).SprintFunc()
+	line := fmt.Sprintf(" %s in context %s, namespace %s ", strings.ToUpper(verb), kubeContext, coalesceString(namespace, "default"))
+	fmt.Fprintf(w, "%s\n", banner(strings.Repeat(" ", len(line))))
+	fmt.Fprintf(w, "%s\n", banner(line))
+	fmt.Fprintf(w, "%s\n", banner(strings.Repeat(" ", len(line))))
+}
+
+// confirmContext asks for the context name and fails unless it is typed exactly
+func confirmContext(in io.Reader, out io.Writer, kubeContext string) error {
+	fmt.Fprintf(out, "Type the context name to continue: ")
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return errors.Wrap(err, "failed to read confirmation")
+	}
+	if strings.TrimSpace(answer) != kubeContext {
+		return fmt.Errorf("confirmation did not match %q, nothing was run", kubeContext)
+	}
+	return nil
+}
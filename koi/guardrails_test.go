@@ -0,0 +1,205 @@
+package koi
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_guardedVerb(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandName string
+		args        []string
+		want        string
+	}{
+		{
+			name: "Delete should be guarded",
+			args: []string{"-n", "koi", "delete", "pod", "foo"},
+			want: "delete",
+		},
+		{
+			name: "Get should not be guarded",
+			args: []string{"get", "pods"},
+			want: "",
+		},
+		{
+			name: "Rollout restart should be guarded",
+			args: []string{"rollout", "--namespace", "restart", "restart", "deploy/foo"},
+			want: "rollout restart",
+		},
+		{
+			name: "Rollout status should not be guarded",
+			args: []string{"rollout", "status", "deploy/foo"},
+			want: "",
+		},
+		{
+			name:        "Koi shell should be guarded",
+			commandName: "shell",
+			args:        []string{"--context", "prod"},
+			want:        "shell",
+		},
+		{
+			name: "Listing shells should not be guarded",
+			args: []string{"shell", "ls"},
+			want: "",
+		},
+		{
+			name:        "Listing shells with kshell should not be guarded",
+			commandName: "shell",
+			args:        []string{"ls", "--context", "prod"},
+			want:        "",
+		},
+		{
+			name: "Attaching to a shell should be guarded",
+			args: []string{"shell", "attach", "koi-shell-abc"},
+			want: "shell",
+		},
+		{
+			name: "Shell gc should be guarded",
+			args: []string{"shell", "gc", "--older-than", "1h"},
+			want: "shell",
+		},
+		{
+			name: "Shell gc dry run should not be guarded",
+			args: []string{"shell", "gc", "--dry-run"},
+			want: "",
+		},
+		{
+			name: "A dry run should not be guarded",
+			args: []string{"apply", "-f", "foo.yaml", "--dry-run=server"},
+			want: "",
+		},
+		{
+			name: "Help should not be guarded",
+			args: []string{"delete", "--help"},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := guardedVerb(defaultGuardedVerbs, tt.commandName, tt.args); got != tt.want {
+				t.Errorf("guardedVerb() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_contextIsProtected(t *testing.T) {
+	tests := []struct {
+		name        string
+		patterns    []string
+		kubeContext string
+		want        bool
+	}{
+		{
+			name:        "A matching glob should protect the context",
+			patterns:    []string{"*prod*"},
+			kubeContext: "eks-prod-us-east-1",
+			want:        true,
+		},
+		{
+			name:        "Other contexts should not be protected",
+			patterns:    []string{"*prod*", "live-?"},
+			kubeContext: "kind-kind",
+			want:        false,
+		},
+		{
+			name:        "A glob should match contexts with slashes in them",
+			patterns:    []string{"*prod*"},
+			kubeContext: "arn:aws:eks:us-east-1:123:cluster/prod-eu",
+			want:        true,
+		},
+		{
+			name:        "An exact name should protect the context",
+			patterns:    []string{"live-1"},
+			kubeContext: "live-1",
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contextIsProtected(tt.patterns, tt.kubeContext); got != tt.want {
+				t.Errorf("contextIsProtected() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractYesFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantYes bool
+	}{
+		{
+			name:    "No --yes should leave args alone",
+			args:    []string{"delete", "pod", "foo"},
+			want:    []string{"delete", "pod", "foo"},
+			wantYes: false,
+		},
+		{
+			name:    "--yes should be removed",
+			args:    []string{"delete", "--yes", "pod", "foo"},
+			want:    []string{"delete", "pod", "foo"},
+			wantYes: true,
+		},
+		{
+			name:    "--yes after a double-dash belongs to the command",
+			args:    []string{"shell", "--yes", "--", "apt-get", "--yes"},
+			want:    []string{"shell", "--", "apt-get", "--yes"},
+			wantYes: true,
+		},
+		{
+			name:    "--yes=false should be removed but not confirm",
+			args:    []string{"delete", "--yes=false", "pod", "foo"},
+			want:    []string{"delete", "pod", "foo"},
+			wantYes: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotYes := ExtractYesFlag(tt.args)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractYesFlag() got: %v, want: %v", got, tt.want)
+			}
+			if gotYes != tt.wantYes {
+				t.Errorf("ExtractYesFlag() gotYes: %v, want: %v", gotYes, tt.wantYes)
+			}
+		})
+	}
+}
+
+func Test_confirmContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{
+			name:    "Typing the context should confirm",
+			input:   "prod-1\n",
+			wantErr: false,
+		},
+		{
+			name:    "Typing something else should refuse",
+			input:   "yes\n",
+			wantErr: true,
+		},
+		{
+			name:    "No input should refuse",
+			input:   "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := confirmContext(strings.NewReader(tt.input), &bytes.Buffer{}, "prod-1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("confirmContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
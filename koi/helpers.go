@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"os"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -12,59 +13,93 @@ import (
 )
 
 func extractValueArgumentFromArgs(args []string, argumentFlags ...string) string {
-	for i, a := range args {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
 		if a == "--" {
 			break
 		}
-		for _, f := range argumentFlags {
-			if a == f && len(args) > (i+1) {
-				return args[i+1]
+		name, value, hasValue := splitFlagArg(a)
+		if flagMatches(name, argumentFlags...) {
+			if hasValue {
+				return value
 			}
-			if strings.HasPrefix(a, f+"=") {
-				return strings.SplitN(a, "=", 2)[1]
+			if len(args) > (i + 1) {
+				return args[i+1]
 			}
 		}
+		if flagConsumesNextArg(a) {
+			i++
+		}
 	}
 	return ""
 }
 
-func extractBoolArgumentFromArgs(args []string, argumentFlags ...string) bool {
-	for _, a := range args {
+// RemoveArg returns args without the first positional occurrence of arg before any double-dash
+// Values of flags are not positional, so `-n shell shell` only has its second "shell" removed
+func RemoveArg(args []string, arg string) []string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
 		if a == "--" {
-			break
+			return args
 		}
-		for _, f := range argumentFlags {
-			if a == f {
-				return true
-			}
-			if strings.HasPrefix(a, f+"=") {
-				val := strings.SplitN(a, "=", 2)[1]
-				ret, err := strconv.ParseBool(val)
-				if err != nil {
-					log.Error(errors.Wrapf(err, "Error parsing flags for %q", argumentFlags))
-				} else {
-					return ret
-				}
-			}
+		if flagConsumesNextArg(a) {
+			i++
+			continue
+		}
+		if a == arg {
+			ret := make([]string, 0, len(args)-1)
+			ret = append(ret, args[:i]...)
+			return append(ret, args[i+1:]...)
 		}
 	}
-	return false
+	return args
 }
 
-func copyArgsIntoNewArgs(oldArgs, newArgs []string, copyValueArgs, copyBoolArgs [][]string) []string {
-	for _, ia := range copyValueArgs {
-		val := extractValueArgumentFromArgs(oldArgs, ia...)
-		if val != "" {
-			newArgs = appendArgument(newArgs, ia[0], val)
+// removeValueFlag returns args without the flags, and their values, which come before any double-dash
+func removeValueFlag(args []string, flags ...string) []string {
+	ret := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(ret, args[i:]...)
+		}
+		name, _, hasValue := splitFlagArg(arg)
+		if flagMatches(name, flags...) {
+			if !hasValue {
+				i++
+			}
+			continue
 		}
+		if flagConsumesNextArg(arg) && i+1 < len(args) {
+			ret = append(ret, arg, args[i+1])
+			i++
+			continue
+		}
+		ret = append(ret, arg)
 	}
-	for _, ia := range copyBoolArgs {
-		val := extractBoolArgumentFromArgs(oldArgs, ia...)
-		if val {
-			newArgs = appendArgument(newArgs, ia[0], "")
+	return ret
+}
+
+func extractBoolArgumentFromArgs(args []string, argumentFlags ...string) bool {
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		name, value, hasValue := splitFlagArg(a)
+		if !flagMatches(name, argumentFlags...) {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		ret, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Error(errors.Wrapf(err, "Error parsing flags for %q", argumentFlags))
+		} else {
+			return ret
 		}
 	}
-	return newArgs
+	return false
 }
 
 func appendArgument(args []string, flag string, val string) []string {
@@ -89,6 +124,16 @@ func appendArgument(args []string, flag string, val string) []string {
 	return ret
 }
 
+// globMatch matches s against a shell style glob where * and ? match any character, slashes included
+// Images and contexts have slashes in them, eg: docker.io/library/nginx:1.27
+func globMatch(pattern string, s string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	matched, _ := regexp.MatchString("^"+expr+"$", s)
+	return matched
+}
+
 func runCommandAndFilterOutput(exe string, args []string, lineFilter ...func(line string) (modifiedLine string, shouldPrint bool)) (exitCode int, runError error) {
 	log.Tracef("going to run command: %q %q", exe, args)
 
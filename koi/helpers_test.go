@@ -42,6 +42,18 @@ func Test_extractValueArgumentFromArgs(t *testing.T) {
 			argumentFlags: []string{"--namespace", "-n"},
 			want:          "koi=bob",
 		},
+		{
+			name:          "Short flags can have their value attached",
+			args:          []string{"-nkoi"},
+			argumentFlags: []string{"--namespace", "-n"},
+			want:          "koi",
+		},
+		{
+			name:          "Values of other flags should not be mistaken for the flag",
+			args:          []string{"--as", "-n", "-n", "koi"},
+			argumentFlags: []string{"--namespace", "-n"},
+			want:          "koi",
+		},
 		{
 			name:          "Flags after the double dash should be ignored",
 			args:          []string{"exec", "--", "-n=koi=bob"},
@@ -118,3 +130,79 @@ func Test_extractBoolArgumentFromArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoveArg(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		arg  string
+		want []string
+	}{
+		{
+			name: "The arg should be removed",
+			args: []string{"shell", "-n", "koi"},
+			arg:  "shell",
+			want: []string{"-n", "koi"},
+		},
+		{
+			name: "Flag values should not be removed",
+			args: []string{"-n", "shell", "shell", "--image", "alpine"},
+			arg:  "shell",
+			want: []string{"-n", "shell", "--image", "alpine"},
+		},
+		{
+			name: "Args after the double dash should not be removed",
+			args: []string{"-n", "koi", "--", "shell"},
+			arg:  "shell",
+			want: []string{"-n", "koi", "--", "shell"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RemoveArg(tt.args, tt.arg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RemoveArg() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_globMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		s       string
+		want    bool
+	}{
+		{
+			name:    "A star should match across slashes",
+			pattern: "*nginx*",
+			s:       "docker.io/library/nginx:1.27",
+			want:    true,
+		},
+		{
+			name:    "A question mark should match one character",
+			pattern: "live-?",
+			s:       "live-1",
+			want:    true,
+		},
+		{
+			name:    "The whole string should have to match",
+			pattern: "prod",
+			s:       "prod-1",
+			want:    false,
+		},
+		{
+			name:    "Regular expression characters should be literal",
+			pattern: "api.v1*",
+			s:       "apixv1",
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := globMatch(tt.pattern, tt.s); got != tt.want {
+				t.Errorf("globMatch() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
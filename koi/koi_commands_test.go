@@ -23,6 +23,26 @@ func TestGetCommand(t *testing.T) {
 			args: []string{"-n", "koi"},
 			want: "",
 		},
+		{
+			name: "Boolean global flags should not swallow the command",
+			args: []string{"--insecure-skip-tls-verify", "get", "pods"},
+			want: "get",
+		},
+		{
+			name: "Flags with an equals sign should not swallow the command",
+			args: []string{"--context=bob", "shell"},
+			want: "shell",
+		},
+		{
+			name: "Short flags with an attached value should not swallow the command",
+			args: []string{"-nkoi", "containers"},
+			want: "containers",
+		},
+		{
+			name: "The -x shorthand should take a value",
+			args: []string{"-x", "bob", "events"},
+			want: "events",
+		},
 		{
 			name: "No args should return no command",
 			args: []string{},
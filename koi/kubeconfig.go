@@ -0,0 +1,214 @@
+package koi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// KubeConnection holds kubectl's global flags which pick the cluster and user koi's own commands connect as
+type KubeConnection struct {
+	Kubeconfig     string
+	Context        string
+	Cluster        string
+	User           string
+	As             string
+	AsGroups       []string
+	RequestTimeout string
+}
+
+// kubeConnectionFlags are the kubectl global flags koi commands take out of their args, --context is left to each command
+var kubeConnectionFlags = []string{"--kubeconfig", "--cluster", "--user", "--as", "--as-group", "--request-timeout"}
+
+// ExtractKubeConnection takes kubectl's connection flags out of args, so koi commands can use them like kubectl does
+func ExtractKubeConnection(args []string) (KubeConnection, []string) {
+	return extractKubeConnection(args, kubeConnectionFlags)
+}
+
+// extractKubeConnection takes only the connection flags in flags out of args
+func extractKubeConnection(args []string, flags []string) (KubeConnection, []string) {
+	conn := KubeConnection{}
+	ret := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return conn, append(ret, args[i:]...)
+		}
+
+		name, value, hasValue := splitFlagArg(arg)
+		if !flagMatches(name, flags...) {
+			if flagConsumesNextArg(arg) && i+1 < len(args) {
+				ret = append(ret, arg, args[i+1])
+				i++
+				continue
+			}
+			ret = append(ret, arg)
+			continue
+		}
+
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+			i++
+		}
+		switch name {
+		case "--kubeconfig":
+			conn.Kubeconfig = value
+		case "--cluster":
+			conn.Cluster = value
+		case "--user":
+			conn.User = value
+		case "--as":
+			conn.As = value
+		case "--as-group":
+			conn.AsGroups = append(conn.AsGroups, value)
+		case "--request-timeout":
+			conn.RequestTimeout = value
+		}
+	}
+	return conn, ret
+}
+
+// kubectlArgs returns the connection as flags for kubectl
+func (conn KubeConnection) kubectlArgs() []string {
+	args := []string{}
+	for _, flag := range []struct {
+		name  string
+		value string
+	}{
+		{"--kubeconfig", conn.Kubeconfig},
+		{"--context", conn.Context},
+		{"--cluster", conn.Cluster},
+		{"--user", conn.User},
+		{"--as", conn.As},
+		{"--request-timeout", conn.RequestTimeout},
+	} {
+		if flag.value != "" {
+			args = append(args, flag.name, flag.value)
+		}
+	}
+	for _, group := range conn.AsGroups {
+		args = append(args, "--as-group", group)
+	}
+	return args
+}
+
+// kubeClientConfig loads the kubeconfig the way kubectl does: --kubeconfig, then the KUBECONFIG list, then ~/.kube/config
+// When there is no kubeconfig and koi runs in a pod, the pod's service account is used
+func kubeClientConfig(conn KubeConnection) clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = conn.Kubeconfig
+	warnKubeConfigEnv()
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: conn.Context,
+		Context: clientcmdapi.Context{
+			Cluster:  conn.Cluster,
+			AuthInfo: conn.User,
+		},
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       conn.As,
+			ImpersonateGroups: conn.AsGroups,
+		},
+		Timeout: conn.RequestTimeout,
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
+
+// warnKubeConfigEnv warns once that KUBE_CONFIG, which koi read before it followed kubectl's rules, is ignored
+// koi commands and kubectl would otherwise talk to different clusters from the same shell
+var warnKubeConfigEnv = sync.OnceFunc(func() {
+	if path := os.Getenv("KUBE_CONFIG"); path != "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+		log.Warnf("KUBE_CONFIG is ignored like kubectl does, set KUBECONFIG=%s to use it", path)
+	}
+})
+
+func getKubeRestConfig(conn KubeConnection) (*rest.Config, error) {
+	restConfig, err := kubeClientConfig(conn).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("building rest config: %w", err)
+	}
+	return restConfig, nil
+}
+
+func getKubeClient(conn KubeConnection) (*kubernetes.Clientset, error) {
+	restConfig, err := getKubeRestConfig(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create clientset
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating clientset: %w", err)
+	}
+	return clientset, nil
+}
+
+// listKubeContexts returns the names of the contexts in the kubeconfig, sorted
+func listKubeContexts(conn KubeConnection) ([]string, error) {
+	config, err := kubeClientConfig(conn).RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	return sortedKeys(config.Contexts), nil
+}
+
+// listNamespaces returns the names of the namespaces in the cluster, sorted
+func listNamespaces(conn KubeConnection, timeout time.Duration) ([]string, error) {
+	client, err := getKubeClient(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	nsList, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+
+	ret := make([]string, 0, len(nsList.Items))
+	for _, ns := range nsList.Items {
+		ret = append(ret, ns.GetName())
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// kubeconfigCurrentContext returns the context of the connection, or the current-context from the kubeconfig
+func kubeconfigCurrentContext(conn KubeConnection) (string, error) {
+	if conn.Context != "" {
+		return conn.Context, nil
+	}
+	config, err := kubeClientConfig(conn).RawConfig()
+	if err != nil {
+		return "", fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	return config.CurrentContext, nil
+}
+
+// kubeconfigNamespace returns the namespace set for the context in the kubeconfig, the pod's namespace in a cluster, or default
+func kubeconfigNamespace(conn KubeConnection) (string, error) {
+	namespace, _, err := kubeClientConfig(conn).Namespace()
+	if err != nil {
+		return "", fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	return namespace, nil
+}
+
+// kubeConnectionFromArgs returns the connection kubectl would use for args, including the context
+func kubeConnectionFromArgs(args []string) KubeConnection {
+	conn, _ := ExtractKubeConnection(args)
+	conn.Context = extractValueArgumentFromArgs(args, "--context", "-x")
+	return conn
+}
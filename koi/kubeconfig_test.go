@@ -0,0 +1,167 @@
+package koi
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtractKubeConnection(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantConn KubeConnection
+		wantArgs []string
+	}{
+		{
+			name:     "Args without connection flags should be left alone",
+			args:     []string{"-n", "kube-system", "--context", "prod"},
+			wantConn: KubeConnection{},
+			wantArgs: []string{"-n", "kube-system", "--context", "prod"},
+		},
+		{
+			name: "Connection flags should be taken out in both forms",
+			args: []string{"--kubeconfig", "/tmp/a", "-n", "default", "--user=admin", "--as-group", "a", "--as-group=b", "pod"},
+			wantConn: KubeConnection{
+				Kubeconfig: "/tmp/a",
+				User:       "admin",
+				AsGroups:   []string{"a", "b"},
+			},
+			wantArgs: []string{"-n", "default", "pod"},
+		},
+		{
+			name:     "The value of another flag should not be taken as a connection flag",
+			args:     []string{"-n", "--cluster", "--as", "bob"},
+			wantConn: KubeConnection{As: "bob"},
+			wantArgs: []string{"-n", "--cluster"},
+		},
+		{
+			name:     "Flags after a double-dash should be left alone",
+			args:     []string{"--request-timeout", "5s", "--", "--as", "bob"},
+			wantConn: KubeConnection{RequestTimeout: "5s"},
+			wantArgs: []string{"--", "--as", "bob"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotConn, gotArgs := ExtractKubeConnection(tt.args)
+			if !reflect.DeepEqual(gotConn, tt.wantConn) {
+				t.Errorf("ExtractKubeConnection() conn got: %+v, want: %+v", gotConn, tt.wantConn)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("ExtractKubeConnection() args got: %q, want: %q", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func Test_kubeClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	writeKubeconfig(t, first, "prod", "prod", "payments")
+	writeKubeconfig(t, second, "", "staging", "web")
+
+	tests := []struct {
+		name          string
+		kubeconfigEnv string
+		conn          KubeConnection
+		wantContexts  []string
+		wantContext   string
+		wantNamespace string
+	}{
+		{
+			name:          "Every file in the KUBECONFIG list should be merged",
+			kubeconfigEnv: first + string(os.PathListSeparator) + second,
+			wantContexts:  []string{"prod", "staging"},
+			wantContext:   "prod",
+			wantNamespace: "payments",
+		},
+		{
+			name:          "The context of the connection should be used over the current-context",
+			kubeconfigEnv: first + string(os.PathListSeparator) + second,
+			conn:          KubeConnection{Context: "staging"},
+			wantContexts:  []string{"prod", "staging"},
+			wantContext:   "staging",
+			wantNamespace: "web",
+		},
+		{
+			name:          "--kubeconfig should be used instead of KUBECONFIG",
+			kubeconfigEnv: first,
+			conn:          KubeConnection{Kubeconfig: second, Context: "staging"},
+			wantContexts:  []string{"staging"},
+			wantContext:   "staging",
+			wantNamespace: "web",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.kubeconfigEnv)
+
+			contexts, err := listKubeContexts(tt.conn)
+			if err != nil {
+				t.Fatalf("listKubeContexts() error: %v", err)
+			}
+			if !reflect.DeepEqual(contexts, tt.wantContexts) {
+				t.Errorf("listKubeContexts() got: %q, want: %q", contexts, tt.wantContexts)
+			}
+
+			kubeContext, err := kubeconfigCurrentContext(tt.conn)
+			if err != nil {
+				t.Fatalf("kubeconfigCurrentContext() error: %v", err)
+			}
+			if kubeContext != tt.wantContext {
+				t.Errorf("kubeconfigCurrentContext() got: %q, want: %q", kubeContext, tt.wantContext)
+			}
+
+			namespace, err := kubeconfigNamespace(tt.conn)
+			if err != nil {
+				t.Fatalf("kubeconfigNamespace() error: %v", err)
+			}
+			if namespace != tt.wantNamespace {
+				t.Errorf("kubeconfigNamespace() got: %q, want: %q", namespace, tt.wantNamespace)
+			}
+		})
+	}
+}
+
+func Test_kubeClientConfig_ignoresKubeConfigEnv(t *testing.T) {
+	other := filepath.Join(t.TempDir(), "other")
+	writeKubeconfig(t, other, "koi-kube-config-env", "koi-kube-config-env", "payments")
+	t.Setenv("KUBECONFIG", "")
+	t.Setenv("KUBE_CONFIG", other)
+
+	// kubectl does not read KUBE_CONFIG, so koi's commands should not either
+	kubeContext, _ := kubeconfigCurrentContext(KubeConnection{})
+	if kubeContext == "koi-kube-config-env" {
+		t.Errorf("kubeconfigCurrentContext() should not read the kubeconfig in KUBE_CONFIG")
+	}
+}
+
+// writeKubeconfig writes a kubeconfig with one context, its cluster is on a port which refuses connections
+// so a test which reaches for it fails at once without going to the network
+func writeKubeconfig(t *testing.T, path string, currentContext string, contextName string, namespace string) {
+	t.Helper()
+	content := `apiVersion: v1
+kind: Config
+current-context: "` + currentContext + `"
+clusters:
+- name: ` + contextName + `
+  cluster:
+    server: https://127.0.0.1:1
+users:
+- name: ` + contextName + `
+  user:
+    token: secret
+contexts:
+- name: ` + contextName + `
+  context:
+    cluster: ` + contextName + `
+    user: ` + contextName + `
+    namespace: ` + namespace + `
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package koi
+
+import "strings"
+
+//go:generate go run ../hack/genflags -o kubectl_flags_gen.go
+
+// kubectlFlag describes a flag which can appear anywhere on the command line
+type kubectlFlag struct {
+	long       string
+	short      string
+	takesValue bool
+}
+
+// koiGlobalFlags are the flags koi understands on top of kubectl's global flags
+var koiGlobalFlags = []kubectlFlag{
+	{long: "context", short: "x", takesValue: true},
+	{long: "jq", takesValue: true},
+	{long: "yq", takesValue: true},
+	{long: "yes"},
+	{long: "contexts", takesValue: true},
+}
+
+func lookupLongFlag(name string) *kubectlFlag {
+	for _, flags := range [][]kubectlFlag{kubectlGlobalFlags, koiGlobalFlags} {
+		for i := range flags {
+			if flags[i].long == name {
+				return &flags[i]
+			}
+		}
+	}
+	return nil
+}
+
+func lookupShortFlag(name string) *kubectlFlag {
+	for _, flags := range [][]kubectlFlag{kubectlGlobalFlags, koiGlobalFlags} {
+		for i := range flags {
+			if flags[i].short == name {
+				return &flags[i]
+			}
+		}
+	}
+	return nil
+}
+
+// splitFlagArg splits a single arg into the flag name (with its dashes) and an inline value
+// It understands --flag=value, -f=value, -fvalue for short flags which take a value and
+// combined short flags like -vn where the last one takes the next arg as its value
+// For args which are not flags, name is empty
+func splitFlagArg(arg string) (name string, value string, hasValue bool) {
+	if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+		return "", "", false
+	}
+
+	if strings.HasPrefix(arg, "--") {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1], true
+		}
+		return arg, "", false
+	}
+
+	if len(arg) > 2 && arg[2] == '=' {
+		return arg[:2], arg[3:], true
+	}
+
+	// Walk combined short boolean flags until we hit one which takes a value
+	// An unknown short flag might take a value itself, so we have to stop there
+	for i := 1; i < len(arg); i++ {
+		flag := lookupShortFlag(arg[i : i+1])
+		if flag == nil {
+			break
+		}
+		if flag.takesValue {
+			if i+1 < len(arg) {
+				return "-" + arg[i:i+1], arg[i+1:], true
+			}
+			return "-" + arg[i:i+1], "", false
+		}
+	}
+	return arg[:2], "", false
+}
+
+// flagConsumesNextArg returns true if arg is a flag whose value is the next arg
+func flagConsumesNextArg(arg string) bool {
+	name, _, hasValue := splitFlagArg(arg)
+	if name == "" || hasValue {
+		return false
+	}
+	var flag *kubectlFlag
+	if strings.HasPrefix(name, "--") {
+		flag = lookupLongFlag(strings.TrimPrefix(name, "--"))
+	} else {
+		flag = lookupShortFlag(strings.TrimPrefix(name, "-"))
+	}
+	return flag != nil && flag.takesValue
+}
+
+// flagMatches returns true if the flag name from splitFlagArg is one of names
+// Names are given with their dashes, eg: "-n", "--namespace"
+func flagMatches(name string, names ...string) bool {
+	return name != "" && stringArrayContains(names, name)
+}
@@ -0,0 +1,36 @@
+// Code generated by hack/genflags from `kubectl options` of kubectl `v1.37.1`; DO NOT EDIT.
+
+package koi
+
+var kubectlGlobalFlags = []kubectlFlag{
+	{long: "as", takesValue: true},
+	{long: "as-group", takesValue: true},
+	{long: "as-uid", takesValue: true},
+	{long: "as-user-extra", takesValue: true},
+	{long: "cache-dir", takesValue: true},
+	{long: "certificate-authority", takesValue: true},
+	{long: "client-certificate", takesValue: true},
+	{long: "client-key", takesValue: true},
+	{long: "cluster", takesValue: true},
+	{long: "context", takesValue: true},
+	{long: "disable-compression"},
+	{long: "insecure-skip-tls-verify"},
+	{long: "kubeconfig", takesValue: true},
+	{long: "kuberc", takesValue: true},
+	{long: "log-flush-frequency", takesValue: true},
+	{long: "match-server-version"},
+	{long: "namespace", short: "n", takesValue: true},
+	{long: "password", takesValue: true},
+	{long: "profile", takesValue: true},
+	{long: "profile-output", takesValue: true},
+	{long: "proxy-url", takesValue: true},
+	{long: "request-timeout", takesValue: true},
+	{long: "server", short: "s", takesValue: true},
+	{long: "tls-server-name", takesValue: true},
+	{long: "token", takesValue: true},
+	{long: "user", takesValue: true},
+	{long: "username", takesValue: true},
+	{long: "v", short: "v", takesValue: true},
+	{long: "vmodule", takesValue: true},
+	{long: "warnings-as-errors"},
+}
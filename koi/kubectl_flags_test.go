@@ -0,0 +1,54 @@
+package koi
+
+import "testing"
+
+func Test_splitFlagArg(t *testing.T) {
+	tests := []struct {
+		arg          string
+		wantName     string
+		wantValue    string
+		wantHasValue bool
+	}{
+		{arg: "get", wantName: ""},
+		{arg: "--", wantName: ""},
+		{arg: "--namespace", wantName: "--namespace"},
+		{arg: "--namespace=koi", wantName: "--namespace", wantValue: "koi", wantHasValue: true},
+		{arg: "-n=koi", wantName: "-n", wantValue: "koi", wantHasValue: true},
+		{arg: "-nkoi", wantName: "-n", wantValue: "koi", wantHasValue: true},
+		{arg: "-v5", wantName: "-v", wantValue: "5", wantHasValue: true},
+		{arg: "-lapp=nginx", wantName: "-l"},
+		{arg: "-it", wantName: "-i"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			name, value, hasValue := splitFlagArg(tt.arg)
+			if name != tt.wantName || value != tt.wantValue || hasValue != tt.wantHasValue {
+				t.Errorf("splitFlagArg() got: %q %q %v, want: %q %q %v", name, value, hasValue, tt.wantName, tt.wantValue, tt.wantHasValue)
+			}
+		})
+	}
+}
+
+func Test_flagConsumesNextArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want bool
+	}{
+		{arg: "-n", want: true},
+		{arg: "--namespace", want: true},
+		{arg: "--namespace=koi", want: false},
+		{arg: "-x", want: true},
+		{arg: "--insecure-skip-tls-verify", want: false},
+		{arg: "--warnings-as-errors", want: false},
+		{arg: "--request-timeout", want: true},
+		{arg: "-o", want: false},
+		{arg: "pods", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			if got := flagConsumesNextArg(tt.arg); got != tt.want {
+				t.Errorf("flagConsumesNextArg() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
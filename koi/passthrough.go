@@ -0,0 +1,54 @@
+package koi
+
+import (
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// RunKubectl runs kubectl with args attached to koi's stdin, stdout and stderr
+// If the invocation has an output filter, kubectl's stdout goes through it
+func RunKubectl(inv Invocation, args []string) (exitCode int, runErr error) {
+	command := inv.Settings.KubectlExe
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	var cmdOut io.ReadCloser
+	if inv.Filter != nil {
+		var pipeErr error
+		cmdOut, pipeErr = cmd.StdoutPipe()
+		if pipeErr != nil {
+			return 1, errors.Wrapf(pipeErr, "Failed to create stdout pipe")
+		}
+	}
+
+	cmdErr := cmd.Start()
+	if cmdErr != nil {
+		return 1, errors.Wrapf(cmdErr, "Failed to start command %q %q", command, args)
+	}
+
+	var filterErr error
+	if inv.Filter != nil {
+		filterErr = inv.Filter.Filter(cmdOut, os.Stdout)
+		// Drain whatever is left so kubectl is not blocked writing to a full pipe
+		io.Copy(io.Discard, cmdOut)
+	}
+
+	ps, cmdErr := cmd.Process.Wait()
+	if cmdOut != nil {
+		cmdOut.Close()
+	}
+	if cmdErr != nil {
+		return 1, errors.Wrapf(cmdErr, "Failed to run command %q %q", command, args)
+	}
+
+	exitCode = ps.ExitCode()
+	if filterErr != nil {
+		return 1, filterErr
+	}
+	return exitCode, nil
+}
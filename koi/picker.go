@@ -0,0 +1,196 @@
+package koi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// errPickerAborted is returned when the user leaves the picker without choosing
+var errPickerAborted = errors.New("nothing was picked")
+
+const pickerMaxRows = 15
+
+// pickInteractively shows a fuzzy-search list of items on the terminal and returns the chosen one
+// The cursor starts on the current item
+func pickInteractively(prompt string, items []string, current string) (string, error) {
+	in := os.Stdin
+	out := os.Stderr
+	if !term.IsTerminal(int(in.Fd())) {
+		return "", fmt.Errorf("cannot pick %s interactively without a terminal", prompt)
+	}
+
+	oldState, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to set up the terminal")
+	}
+	defer term.Restore(int(in.Fd()), oldState)
+
+	query := ""
+	matches := fuzzyFilter(query, items)
+	cursor := indexOf(matches, current)
+	drawnRows := 0
+
+	for {
+		drawnRows = drawPicker(out, prompt, query, matches, len(items), cursor, current, drawnRows)
+
+		buf := make([]byte, 16)
+		n, err := in.Read(buf)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read from the terminal")
+		}
+		key := string(buf[:n])
+
+		switch key {
+		case "\r", "\n":
+			clearPicker(out, drawnRows)
+			if len(matches) == 0 {
+				return "", errPickerAborted
+			}
+			return matches[cursor], nil
+		case "\x03", "\x1b", "\x04":
+			clearPicker(out, drawnRows)
+			return "", errPickerAborted
+		case "\x1b[A", "\x10":
+			if cursor > 0 {
+				cursor--
+			}
+			continue
+		case "\x1b[B", "\x0e":
+			if cursor < len(matches)-1 {
+				cursor++
+			}
+			continue
+		case "\x7f", "\x08":
+			if len(query) > 0 {
+				runes := []rune(query)
+				query = string(runes[:len(runes)-1])
+			}
+		case "\x15":
+			query = ""
+		default:
+			for _, r := range key {
+				if unicode.IsPrint(r) {
+					query += string(r)
+				}
+			}
+		}
+
+		matches = fuzzyFilter(query, items)
+		cursor = 0
+		if query == "" {
+			cursor = indexOf(matches, current)
+		}
+	}
+}
+
+// drawPicker redraws the picker in place and returns the number of rows it used
+func drawPicker(out io.Writer, prompt string, query string, matches []string, total int, cursor int, current string, previousRows int) int {
+	var b strings.Builder
+	if previousRows > 1 {
+		fmt.Fprintf(&b, "\x1b[%dA", previousRows-1)
+	}
+	b.WriteString("\r\x1b[J")
+
+	start := 0
+	if cursor >= pickerMaxRows {
+		start = cursor - pickerMaxRows + 1
+	}
+	end := start + pickerMaxRows
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	rows := 1
+	for i := start; i < end; i++ {
+		line := "  " + matches[i]
+		if matches[i] == current {
+			line += " (current)"
+		}
+		if i == cursor {
+			line = color.New(color.FgHiWhite, color.Bold).Sprint("> " + strings.TrimPrefix(line, "  "))
+		}
+		b.WriteString(line + "\r\n")
+		rows++
+	}
+	fmt.Fprintf(&b, "%s %d/%d > %s", prompt, len(matches), total, query)
+
+	io.WriteString(out, b.String())
+	return rows
+}
+
+func clearPicker(out io.Writer, rows int) {
+	if rows > 1 {
+		fmt.Fprintf(out, "\x1b[%dA", rows-1)
+	}
+	io.WriteString(out, "\r\x1b[J")
+}
+
+func indexOf(items []string, item string) int {
+	for i, v := range items {
+		if v == item {
+			return i
+		}
+	}
+	return 0
+}
+
+// fuzzyFilter returns the items which contain the letters of query in order, best matches first
+func fuzzyFilter(query string, items []string) []string {
+	type scored struct {
+		item  string
+		score int
+	}
+	matched := []scored{}
+	for _, item := range items {
+		if score, ok := fuzzyMatch(query, item); ok {
+			matched = append(matched, scored{item, score})
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].score > matched[j].score })
+
+	ret := make([]string, 0, len(matched))
+	for _, m := range matched {
+		ret = append(ret, m.item)
+	}
+	return ret
+}
+
+// fuzzyMatch checks that the letters of pattern appear in candidate in order, ignoring case
+// Consecutive letters and matches at the start of a word score higher
+func fuzzyMatch(pattern string, candidate string) (score int, ok bool) {
+	p := []rune(strings.ToLower(pattern))
+	c := []rune(strings.ToLower(candidate))
+	if len(p) == 0 {
+		return 0, true
+	}
+
+	pi := 0
+	lastMatch := -2
+	for ci := 0; ci < len(c) && pi < len(p); ci++ {
+		if c[ci] != p[pi] {
+			continue
+		}
+		score++
+		if ci == lastMatch+1 {
+			score += 5
+		}
+		if ci == 0 || strings.ContainsRune("-_./: ", c[ci-1]) {
+			score += 3
+		}
+		lastMatch = ci
+		pi++
+	}
+	if pi < len(p) {
+		return 0, false
+	}
+	// Prefer shorter candidates when everything else is equal
+	return score*100 - len(c), true
+}
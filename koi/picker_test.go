@@ -0,0 +1,49 @@
+package koi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_fuzzyFilter(t *testing.T) {
+	items := []string{"kube-system", "default", "payments-staging", "payments", "monitoring"}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "An empty query should keep every item in order",
+			query: "",
+			want:  items,
+		},
+		{
+			name:  "Letters in order should match",
+			query: "pmts",
+			want:  []string{"payments", "payments-staging"},
+		},
+		{
+			name:  "Matching should ignore case",
+			query: "KS",
+			want:  []string{"kube-system"},
+		},
+		{
+			name:  "Consecutive letters should rank higher",
+			query: "mnt",
+			want:  []string{"payments", "payments-staging", "monitoring"},
+		},
+		{
+			name:  "No match should return nothing",
+			query: "xyz",
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fuzzyFilter(tt.query, items)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fuzzyFilter() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
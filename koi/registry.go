@@ -0,0 +1,229 @@
+package koi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// Invocation is what a koi command gets to run with
+type Invocation struct {
+	Settings Settings
+	// RawArgs are the args as they were typed, before aliases and tweaks
+	RawArgs []string
+	// KubectlArgs are all of the args after koi's tweaks, as they would be passed to kubectl
+	KubectlArgs []string
+	// Args are the positional args left once the command's flags are parsed
+	Args []string
+	// ArgsLenAtDash is the number of Args which came before a double-dash, or -1 if there was none
+	ArgsLenAtDash int
+	Filter        *OutputFilter
+	// Connection holds kubectl's global flags which change the cluster or user, for commands which use client-go
+	Connection KubeConnection
+	// Audit is the record written once the command finishes, commands can add to it, eg: the shell reason
+	Audit *AuditRecord
+}
+
+// RunFunc runs a koi command once its flags are parsed
+type RunFunc func(inv Invocation) (exitCode int, runError error)
+
+// Command is a koi command which runs instead of being passed through to kubectl
+type Command struct {
+	Name string
+	// Symlinks are binary names which run this command directly, eg: kshell
+	Symlinks []string
+	Summary  string
+	Usage    string
+	// Setup declares the command's flags, with defaults from settings, and returns the function which runs the command
+	Setup func(f *pflag.FlagSet, settings Settings) RunFunc
+	// PassUnknownFlags ignores flags the command does not declare, such as kubectl's global flags
+	PassUnknownFlags bool
+	// Matches can limit when koi handles the command, anything else goes to kubectl
+	Matches func(args []string) bool
+	// DisableFlagParsing passes every arg to the command as a positional arg
+	DisableFlagParsing bool
+	// Complete returns shell completions for the command's positional args
+	Complete func(settings Settings, args []string, toComplete string) []string
+	Hidden   bool
+	// SkipAudit leaves the command out of the audit log, for commands which only read koi's own state
+	SkipAudit bool
+	// SkipFilter ignores --jq and --yq, for commands whose args are not a command line to run, eg: completion
+	SkipFilter bool
+}
+
+var registry = []*Command{}
+
+// RegisterCommand adds a command to koi
+func RegisterCommand(cmd *Command) {
+	registry = append(registry, cmd)
+}
+
+// Commands returns the registered commands, sorted by name
+func Commands() []*Command {
+	ret := append([]*Command{}, registry...)
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func commandNames() []string {
+	names := []string{}
+	for _, cmd := range Commands() {
+		if !cmd.Hidden {
+			names = append(names, cmd.Name)
+		}
+	}
+	return names
+}
+
+func findCommand(name string) *Command {
+	for _, cmd := range registry {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// LookupCommand finds the koi command for the binary name (eg: kshell) and args
+// The returned args are the ones to pass to the command's Run
+// A nil command means the args should be passed to kubectl
+func LookupCommand(binaryName string, args []string) (*Command, []string) {
+	if extractBoolArgumentFromArgs(args, "--koi-help") {
+		helpArgs := []string{}
+		if name := GetCommand(RemoveArg(args, "--koi-help")); name != "" {
+			helpArgs = append(helpArgs, name)
+		}
+		return findCommand("help"), helpArgs
+	}
+
+	for _, cmd := range registry {
+		if stringArrayContains(cmd.Symlinks, binaryName) {
+			return cmd, args
+		}
+	}
+
+	name := GetCommand(args)
+	cmd := findCommand(name)
+	if cmd == nil || (cmd.Matches != nil && !cmd.Matches(args)) {
+		return nil, args
+	}
+	return cmd, RemoveArg(args, name)
+}
+
+// Run parses the command's flags and runs it
+func (c *Command) Run(inv Invocation, args []string) (exitCode int, runError error) {
+	f := c.flagSet()
+	run := c.Setup(f, inv.Settings)
+	if c.DisableFlagParsing {
+		inv.Args = args
+		inv.ArgsLenAtDash = -1
+		return run(inv)
+	}
+
+	inv.Connection, args = extractKubeConnection(args, commandConnectionFlags(f))
+	err := f.Parse(args)
+	if err == pflag.ErrHelp {
+		c.PrintHelp(os.Stdout, inv.Settings)
+		return 0, nil
+	}
+	if err != nil {
+		return 1, errors.Wrapf(err, "parsing flags for %s (see: koi help %s)", c.Name, c.Name)
+	}
+
+	inv.Args = f.Args()
+	inv.ArgsLenAtDash = f.ArgsLenAtDash()
+	return run(inv)
+}
+
+// commandConnectionFlags returns the connection flags the command does not define itself, eg: koi audit has its own --user
+func commandConnectionFlags(f *pflag.FlagSet) []string {
+	ret := []string{}
+	for _, name := range kubeConnectionFlags {
+		if f.Lookup(strings.TrimPrefix(name, "--")) == nil {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func (c *Command) flagSet() *pflag.FlagSet {
+	f := pflag.NewFlagSet(c.Name, pflag.ContinueOnError)
+	f.ParseErrorsWhitelist.UnknownFlags = c.PassUnknownFlags
+	f.SetOutput(io.Discard)
+	f.Usage = func() {}
+	return f
+}
+
+// PrintHelp writes the usage, summary and flags of the command
+func (c *Command) PrintHelp(w io.Writer, settings Settings) {
+	fmt.Fprintf(w, "Usage: %s\n", c.Usage)
+	for _, symlink := range c.Symlinks {
+		fmt.Fprintf(w, "       %s\n", strings.Replace(c.Usage, "koi "+c.Name, symlink, 1))
+	}
+	fmt.Fprintf(w, "\n%s\n", c.Summary)
+
+	f := c.flagSet()
+	c.Setup(f, settings)
+	if f.HasFlags() {
+		fmt.Fprintf(w, "\nFlags:\n%s", f.FlagUsages())
+	}
+}
+
+// PrintCommandList writes the list of koi's own commands
+func PrintCommandList(w io.Writer) {
+	fmt.Fprintln(w, "koi is a wrapper around kubectl. Anything koi does not handle itself is passed to kubectl.")
+	fmt.Fprintln(w, "\nkoi commands:")
+	for _, cmd := range Commands() {
+		if cmd.Hidden {
+			continue
+		}
+		name := cmd.Name
+		if len(cmd.Symlinks) > 0 {
+			name = fmt.Sprintf("%s (%s)", name, strings.Join(cmd.Symlinks, ", "))
+		}
+		fmt.Fprintf(w, "  %-28s %s\n", name, cmd.Summary)
+	}
+	fmt.Fprintln(w, "\nkoi flags, usable with any kubectl command:")
+	fmt.Fprintf(w, "  %-28s %s\n", "-x, --context", "The kubeconfig context to use")
+	fmt.Fprintf(w, "  %-28s %s\n", "--jq FILTER, -o jq=FILTER", "Filter the output with a jq expression")
+	fmt.Fprintf(w, "  %-28s %s\n", "--yq FILTER, -o yq=FILTER", "Filter the output with a jq expression and print it as yaml")
+	fmt.Fprintf(w, "  %-28s %s\n", "--yes", "Skip the confirmation for protected contexts")
+	fmt.Fprintf(w, "  %-28s %s\n", "--contexts PATTERNS", "Run the command in every context matching a comma separated list of globs")
+	fmt.Fprintln(w, "\nRun `koi help COMMAND` for more about a koi command, or `kubectl help` for kubectl's commands.")
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "help",
+		Summary: "Show help for koi and its commands",
+		Usage:   "koi help [COMMAND]",
+		Setup: func(f *pflag.FlagSet, settings Settings) RunFunc {
+			return func(inv Invocation) (int, error) {
+				if len(inv.Args) == 0 {
+					PrintCommandList(os.Stdout)
+					return 0, nil
+				}
+				cmd := findCommand(inv.Args[0])
+				if cmd == nil {
+					return 1, fmt.Errorf("%q is not a koi command", inv.Args[0])
+				}
+				cmd.PrintHelp(os.Stdout, inv.Settings)
+				return 0, nil
+			}
+		},
+		PassUnknownFlags: true,
+		Complete: func(settings Settings, args []string, toComplete string) []string {
+			return commandNames()
+		},
+		// `koi help get` is kubectl's help
+		Matches: func(args []string) bool {
+			topic := GetCommand(RemoveArg(args, "help"))
+			return topic == "" || findCommand(topic) != nil
+		},
+	})
+}
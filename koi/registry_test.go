@@ -0,0 +1,116 @@
+package koi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestLookupCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		binaryName string
+		args       []string
+		wantName   string
+		wantArgs   []string
+	}{
+		{
+			name:       "kubectl commands should not be found",
+			binaryName: "koi",
+			args:       []string{"get", "pods"},
+			wantName:   "",
+			wantArgs:   []string{"get", "pods"},
+		},
+		{
+			name:       "koi commands should be found and removed from the args",
+			binaryName: "koi",
+			args:       []string{"--context", "bob", "shell", "-i", "alpine"},
+			wantName:   "shell",
+			wantArgs:   []string{"--context", "bob", "-i", "alpine"},
+		},
+		{
+			name:       "Symlinks should run their command with all args",
+			binaryName: "kcontainers",
+			args:       []string{"-n", "koi"},
+			wantName:   "containers",
+			wantArgs:   []string{"-n", "koi"},
+		},
+		{
+			name:       "kubectl config subcommands should go to kubectl",
+			binaryName: "koi",
+			args:       []string{"config", "use-context", "bob"},
+			wantName:   "",
+			wantArgs:   []string{"config", "use-context", "bob"},
+		},
+		{
+			name:       "koi config subcommands should be found",
+			binaryName: "koi",
+			args:       []string{"config", "use-profile", "work"},
+			wantName:   "config",
+			wantArgs:   []string{"use-profile", "work"},
+		},
+		{
+			name:       "Help for a koi command should be found",
+			binaryName: "koi",
+			args:       []string{"help", "shell"},
+			wantName:   "help",
+			wantArgs:   []string{"shell"},
+		},
+		{
+			name:       "Help for kubectl commands should go to kubectl",
+			binaryName: "koi",
+			args:       []string{"help", "get"},
+			wantName:   "",
+			wantArgs:   []string{"help", "get"},
+		},
+		{
+			name:       "--koi-help should show help for the command",
+			binaryName: "koi",
+			args:       []string{"events", "--koi-help"},
+			wantName:   "help",
+			wantArgs:   []string{"events"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, gotArgs := LookupCommand(tt.binaryName, tt.args)
+			gotName := ""
+			if cmd != nil {
+				gotName = cmd.Name
+			}
+			if gotName != tt.wantName {
+				t.Errorf("LookupCommand() command got: %v, want: %v", gotName, tt.wantName)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("LookupCommand() args got: %v, want: %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCommandRunKeepsItsOwnFlags(t *testing.T) {
+	audit := *findCommand("audit")
+	setup := audit.Setup
+	var gotUser string
+	var gotConn KubeConnection
+	audit.Setup = func(f *pflag.FlagSet, settings Settings) RunFunc {
+		setup(f, settings)
+		return func(inv Invocation) (int, error) {
+			gotUser = f.Lookup("user").Value.String()
+			gotConn = inv.Connection
+			return 0, nil
+		}
+	}
+
+	_, err := audit.Run(Invocation{}, []string{"--user", "alice", "--cluster", "prod"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if gotUser != "alice" {
+		t.Errorf("Run() koi audit --user got: %q, want: %q", gotUser, "alice")
+	}
+	if want := (KubeConnection{Cluster: "prod"}); !reflect.DeepEqual(gotConn, want) {
+		t.Errorf("Run() connection got: %+v, want: %+v", gotConn, want)
+	}
+}
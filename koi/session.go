@@ -0,0 +1,114 @@
+package koi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/util/homedir"
+)
+
+// SessionState is the context and namespace picked with `koi ctx` and `koi ns` for one terminal
+type SessionState struct {
+	Context           string `yaml:"context,omitempty"`
+	Namespace         string `yaml:"namespace,omitempty"`
+	PreviousContext   string `yaml:"previousContext,omitempty"`
+	PreviousNamespace string `yaml:"previousNamespace,omitempty"`
+}
+
+// Session files which have not been touched for this long belong to terminals which are long gone
+const sessionMaxAge = 7 * 24 * time.Hour
+
+// Terminal emulators and multiplexers which set an id for each terminal
+// Panes come first: every pane of a tmux started in iTerm inherits the same ITERM_SESSION_ID
+var terminalSessionEnvs = []string{"TMUX_PANE", "WEZTERM_PANE", "KITTY_WINDOW_ID", "TERM_SESSION_ID", "ITERM_SESSION_ID", "WT_SESSION"}
+
+var sessionKeyRegex = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// sessionKey identifies the current terminal
+// KOI_SESSION wins, then an id set by the terminal, then the pid of the shell running koi
+func sessionKey() string {
+	if key := os.Getenv("KOI_SESSION"); key != "" {
+		return sessionKeyRegex.ReplaceAllString(key, "_")
+	}
+	for _, env := range terminalSessionEnvs {
+		if key := os.Getenv(env); key != "" {
+			return sessionKeyRegex.ReplaceAllString(env+"-"+key, "_")
+		}
+	}
+	return fmt.Sprintf("ppid-%d", os.Getppid())
+}
+
+// stateDir is where koi keeps files it writes itself, such as sessions and the audit log
+func stateDir() string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		stateHome = filepath.Join(homedir.HomeDir(), ".local", "state")
+	}
+	return filepath.Join(stateHome, "koi")
+}
+
+func sessionDir() string {
+	return filepath.Join(stateDir(), "sessions")
+}
+
+func sessionPath() string {
+	return filepath.Join(sessionDir(), sessionKey()+".yaml")
+}
+
+// LoadSessionState reads the state for the current terminal. A missing file is an empty state.
+func LoadSessionState() (SessionState, error) {
+	state := SessionState{}
+	path := sessionPath()
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, errors.Wrapf(err, "failed to read session state %s", path)
+	}
+	err = yaml.Unmarshal(content, &state)
+	return state, errors.Wrapf(err, "failed to parse session state %s", path)
+}
+
+// SaveSessionState writes the state for the current terminal and cleans up old sessions
+func SaveSessionState(state SessionState) error {
+	dir := sessionDir()
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create session dir %s", dir)
+	}
+
+	content, err := yaml.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode session state")
+	}
+	path := sessionPath()
+	err = os.WriteFile(path, content, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write session state %s", path)
+	}
+
+	removeOldSessions(dir)
+	return nil
+}
+
+func removeOldSessions(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < sessionMaxAge {
+			continue
+		}
+		log.Debugf("Removing old session %s", entry.Name())
+		os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
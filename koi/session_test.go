@@ -0,0 +1,45 @@
+package koi
+
+import "testing"
+
+func Test_sessionKey(t *testing.T) {
+	tests := []struct {
+		name string
+		envs map[string]string
+		want string
+	}{
+		{
+			name: "KOI_SESSION should win",
+			envs: map[string]string{"KOI_SESSION": "work", "TMUX_PANE": "%3"},
+			want: "work",
+		},
+		{
+			name: "The terminal's id should be used",
+			envs: map[string]string{"ITERM_SESSION_ID": "w0t1p0:ABC"},
+			want: "ITERM_SESSION_ID-w0t1p0_ABC",
+		},
+		{
+			name: "A tmux pane should win over the terminal its panes inherit",
+			envs: map[string]string{"ITERM_SESSION_ID": "w0t1p0:ABC", "TMUX_PANE": "%3"},
+			want: "TMUX_PANE-_3",
+		},
+		{
+			name: "A wezterm pane should win over the terminal its panes inherit",
+			envs: map[string]string{"WT_SESSION": "abc", "WEZTERM_PANE": "7"},
+			want: "WEZTERM_PANE-7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range append([]string{"KOI_SESSION"}, terminalSessionEnvs...) {
+				t.Setenv(env, "")
+			}
+			for key, value := range tt.envs {
+				t.Setenv(key, value)
+			}
+			if got := sessionKey(); got != tt.want {
+				t.Errorf("sessionKey() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package koi
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
+	"maps"
 	"math/rand"
 	"os"
 	"os/exec"
+	"reflect"
 	"strings"
 	"time"
 
@@ -25,32 +27,155 @@ type ShellInvocation struct {
 	reason    string
 	command   []string
 	timeout   time.Duration
+	// target is a pod to debug with an ephemeral container instead of starting a new pod, eg: pod/api-1
+	target string
+	// targetContainer is the container of the target whose processes the shell can see, or the container of from the shell copies
+	targetContainer string
+	// from is a workload whose pod the shell copies, eg: deployment/api
+	from string
+	// fromTemplate is the pod template of from, already turned into a shell
+	fromTemplate *k8sv1.PodTemplateSpec
+	// node pins the shell pod to a node, taints on it are tolerated
+	node        string
+	hostNetwork bool
+	hostPID     bool
+	privileged  bool
+	tolerations []string
+	// nodeShell runs the shell in the node's filesystem with the node's namespaces
+	nodeShell bool
+	// template is the shell template from the config, the flags below are added on top of it
+	template       ShellTemplate
+	serviceAccount string
+	env            []string
+	requests       map[string]string
+	limits         map[string]string
+	// ttl is how long the pod can run before kubernetes stops it, in case koi cannot delete it
+	ttl time.Duration
+	// user, host and createdAt say who started the shell, they are labels on the pod
+	user      string
+	host      string
+	createdAt time.Time
+	// keep leaves the pod running when the shell exits, to attach to it again
+	keep bool
+	// connection holds the kubectl connection flags, such as --kubeconfig, given to koi
+	connection KubeConnection
 }
 
-func ShellCommand(exe string, args []string) (exitCode int, runError error) {
-	shell := ShellInvocation{}
-
-	defaultPodPrefix := defaultEnv("USER", "koi")
-	defaultPodName := fmt.Sprintf("%s-shell-%d", defaultPodPrefix, rand.Intn(1000))
+func init() {
+	RegisterCommand(&Command{
+		Name:     "shell",
+		Symlinks: []string{"kshell"},
+		Summary:  "Start a temporary pod and open a shell in it, the pod is deleted when the shell exits",
+		Usage:    "koi shell [flags] [-- command...] | koi shell ls | koi shell attach NAME [-- command...] | koi shell gc [--older-than 24h] [--dry-run]",
+		Complete: func(settings Settings, args []string, toComplete string) []string {
+			if len(args) == 0 {
+				return []string{"ls", "attach", "gc"}
+			}
+			if len(args) == 1 && args[0] == "attach" {
+				ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+				defer cancel()
+				pods, _ := listShellPods(ctx, KubeConnection{Context: settings.Context}, "", defaultEnv("USER", "koi"))
+				names := []string{}
+				for _, pod := range pods {
+					names = append(names, pod.Name)
+				}
+				return names
+			}
+			return nil
+		},
+		Setup: func(f *flag.FlagSet, settings Settings) RunFunc {
+			shell := ShellInvocation{}
 
-	f := flag.NewFlagSet("shell", flag.ExitOnError)
-	f.StringVarP(&shell.namespace, "namespace", "n", "", "The namespace to use")
-	f.StringVarP(&shell.context, "context", "x", "", "The context to use")
-	f.StringVarP(&shell.image, "image", "i", "oliverisaac/alpine-nettools:latest", "The image to use")
-	f.StringVarP(&shell.reason, "reason", "r", "", "The reason for the shell")
-	f.StringVar(&shell.name, "name", defaultEnv("KSHELL_NAME", defaultPodName), "The reason for the shell")
-	debug := f.Bool("debug", false, "Enable debug logging")
-	f.DurationVarP(&shell.timeout, "timeout", "t", 2*time.Minute, "Startup timeout duration (e.g. 2m)")
+			defaultPodPrefix := defaultEnv("USER", "koi")
+			defaultPodName := fmt.Sprintf("%s-shell-%d", defaultPodPrefix, rand.Intn(1000))
 
-	f.Parse(args)
+			f.StringVarP(&shell.namespace, "namespace", "n", "", "The namespace to use")
+			f.StringVarP(&shell.context, "context", "x", "", "The context to use")
+			f.StringVarP(&shell.image, "image", "i", settings.Shell.Image, "The image to use")
+			f.StringVarP(&shell.reason, "reason", "r", "", "The reason for the shell")
+			f.StringVar(&shell.name, "name", coalesceString(settings.Shell.Name, defaultPodName), "The name of the shell pod")
+			debug := f.Bool("debug", false, "Enable debug logging")
+			f.DurationVarP(&shell.timeout, "timeout", "t", 2*time.Minute, "Startup timeout duration (e.g. 2m)")
+			f.StringVar(&shell.target, "target", "", "Debug this pod with an ephemeral container which shares its namespaces instead of starting a new pod, eg: pod/api-1")
+			f.StringVarP(&shell.targetContainer, "container", "c", "", "The container of --target whose processes the shell can see, or of --from to copy, the pod's default container if not set")
+			f.StringVar(&shell.from, "from", "", "Start the shell with the image, env, mounts and service account of a workload's pod, without running the app, eg: deployment/api")
+			f.StringVar(&shell.node, "node", "", "Run the shell pod on this node, whatever its taints")
+			f.BoolVar(&shell.hostNetwork, "host-network", false, "Use the node's network namespace")
+			f.BoolVar(&shell.hostPID, "host-pid", false, "Use the node's process namespace")
+			f.BoolVar(&shell.privileged, "privileged", false, "Run the shell container as privileged")
+			f.StringArrayVar(&shell.tolerations, "toleration", nil, "Tolerate a taint, as KEY[=VALUE][:EFFECT] or * for every taint, can be repeated")
+			f.BoolVar(&shell.nodeShell, "node-shell", false, "Open a shell on the node itself: privileged, with the node's namespaces and its filesystem as /, needs --node")
+			templateName := f.String("template", "", "Use a shell template from the config file, the other flags are added on top of it")
+			f.StringVar(&shell.serviceAccount, "service-account", "", "The service account the shell pod runs as")
+			f.StringArrayVarP(&shell.env, "env", "e", nil, "Set an environment variable, as KEY=VALUE, can be repeated")
+			f.StringToStringVar(&shell.requests, "requests", nil, "Resource requests, eg: cpu=100m,memory=128Mi")
+			f.StringToStringVar(&shell.limits, "limits", nil, "Resource limits, eg: memory=256Mi")
+			defaultTTL := settings.Shell.TTL
+			if defaultTTL == 0 {
+				defaultTTL = defaultShellTTL
+			}
+			f.DurationVar(&shell.ttl, "ttl", defaultTTL, "How long the shell pod can run before kubernetes stops it, 0 for no limit")
+			f.BoolVar(&shell.keep, "keep", false, "Leave the shell pod running when the shell exits, `koi shell attach NAME` opens a shell in it again")
+			lsOpts := ShellListOptions{}
+			f.StringVar(&lsOpts.contexts, "contexts", "", "ls: list shell pods in every context matching a comma separated list of globs, eg: prod-*,staging")
+			f.BoolVar(&lsOpts.allContexts, "all-contexts", false, "ls: list shell pods in every context of the kubeconfig")
+			f.BoolVar(&lsOpts.allUsers, "all-users", false, "ls: list everyone's shell pods, not only yours")
+			olderThan := f.Duration("older-than", 24*time.Hour, "gc: delete shell pods older than this")
+			dryRun := f.Bool("dry-run", false, "gc: only list the shell pods which would be deleted")
 
-	if *debug {
-		log.SetLevel(log.TraceLevel)
-	}
-
-	shell.command = f.Args()
+			return func(inv Invocation) (int, error) {
+				if *debug {
+					log.SetLevel(log.TraceLevel)
+				}
+				shell.command = inv.Args
+				shell.connection = inv.Connection
+				// Subcommands come before any double-dash, what comes after it is the command to run
+				subcommand := inv.Args
+				if inv.ArgsLenAtDash >= 0 {
+					subcommand, shell.command = inv.Args[:inv.ArgsLenAtDash], inv.Args[inv.ArgsLenAtDash:]
+				}
+				if len(subcommand) > 0 {
+					switch subcommand[0] {
+					case "gc":
+						return ShellGCCommand(&shell, *olderThan, *dryRun)
+					case "ls":
+						return ShellListCommand(&shell, lsOpts)
+					case "attach":
+						if len(subcommand) != 2 {
+							return 1, fmt.Errorf("usage: koi shell attach NAME [-- command...]")
+						}
+						return ShellAttachCommand(inv.Settings, &shell, subcommand[1])
+					}
+					// Without a double-dash everything is the command, eg: kshell bash
+					shell.command = inv.Args
+				}
+				if *templateName != "" {
+					template, ok := inv.Settings.ShellTemplates[*templateName]
+					if !ok {
+						return 1, fmt.Errorf("shell template %q does not exist in %s, available templates: %q", *templateName, inv.Settings.ConfigPath, sortedKeys(inv.Settings.ShellTemplates))
+					}
+					shell.template = template
+					if template.Image != "" && !f.Changed("image") {
+						shell.image = template.Image
+					}
+				}
+				if shell.from != "" && !f.Changed("image") {
+					// The workload's own image is kept
+					shell.image = shell.template.Image
+				}
+				exitCode, err := ShellCommand(inv.Settings, &shell)
+				if inv.Audit != nil {
+					inv.Audit.Reason = shell.reason
+				}
+				return exitCode, err
+			}
+		},
+	})
+}
 
-	for shell.reason == "" && os.Getenv("KOI_SHELL_REQUIRE_REASON") == "true" {
+// ShellCommand runs the shell, the reason is filled in on shell if the user is asked for one
+func ShellCommand(settings Settings, shell *ShellInvocation) (exitCode int, runError error) {
+	for shell.reason == "" && settings.Shell.RequireReason {
 		log.Error("You must provide a reason for the shell")
 		fmt.Print("Enter a reason for this shell: ")
 		_, err := fmt.Scanf("%s", &shell.reason)
@@ -59,56 +184,117 @@ func ShellCommand(exe string, args []string) (exitCode int, runError error) {
 		}
 	}
 
-	podJSON, err := getPodJSON(shell)
+	if len(shell.command) == 0 {
+		log.Debug("Running default shell command")
+		shell.command = defaultShellCommand(settings)
+	}
+
+	shell.user = coalesceString(shell.user, defaultEnv("USER", "koi"))
+	if shell.host == "" {
+		shell.host, _ = os.Hostname()
+	}
+	shell.createdAt = time.Now()
+
+	conn := shell.connection
+	conn.Context = shell.context
+
+	if shell.nodeShell {
+		if shell.node == "" {
+			return 1, fmt.Errorf("--node-shell needs the --node to open a shell on")
+		}
+		// The node's filesystem is mounted at /host
+		shell.command = append([]string{"chroot", "/host"}, shell.command...)
+	}
+
+	if shell.target != "" {
+		if shell.from != "" || shell.keep {
+			return 1, fmt.Errorf("--target cannot be used with --from or --keep")
+		}
+		if shell.node != "" || shell.hostNetwork || shell.hostPID || shell.privileged || len(shell.tolerations) > 0 || shell.nodeShell {
+			return 1, fmt.Errorf("--target runs in the target pod, so it cannot be used with --node, --host-network, --host-pid, --privileged, --toleration or --node-shell")
+		}
+		if !reflect.DeepEqual(shell.template, ShellTemplate{}) || shell.serviceAccount != "" || len(shell.env) > 0 || len(shell.requests) > 0 || len(shell.limits) > 0 {
+			return 1, fmt.Errorf("--target adds a container to an existing pod, so it cannot be used with --template, --service-account, --env, --requests or --limits")
+		}
+	}
+
+	client, err := newShellClient(conn, shell.namespace)
 	if err != nil {
-		return 1, fmt.Errorf("Failed to generate pod JSON: %w", err)
+		return 1, err
 	}
 
-	kubectlArgs := []string{"kubectl"}
-	if shell.context != "" {
-		kubectlArgs = append(kubectlArgs, "--context", shell.context)
+	if shell.target != "" {
+		ctx, exitCodeForSignal, stopSignals := notifyShellSignals()
+		defer stopSignals()
+		// `kubectl debug --copy-to` is still run by kubectl, for clusters which do not allow ephemeral containers
+		kubectlArgs := append([]string{settings.KubectlExe}, conn.kubectlArgs()...)
+		kubectlArgs = append(kubectlArgs, "--namespace", client.namespace)
+		exitCode, err := shellInTargetPod(ctx, shell, client, kubectlArgs)
+		if ctx.Err() != nil {
+			return exitCodeForSignal(), nil
+		}
+		return exitCode, err
 	}
 
-	if shell.namespace != "" {
-		kubectlArgs = append(kubectlArgs, "--namespace", shell.namespace)
+	if shell.from != "" {
+		template, err := workloadPodTemplate(client, shell.from, shell.targetContainer)
+		if err != nil {
+			return 1, err
+		}
+		shell.fromTemplate = &template
 	}
 
+	pod, err := shellPod(*shell)
+	if err != nil {
+		return 1, fmt.Errorf("Failed to generate the shell pod: %w", err)
+	}
+
+	// A signal stops the shell the way exiting it does, so the pod is still deleted and the terminal restored
+	ctx, exitCodeForSignal, stopSignals := notifyShellSignals()
+	defer stopSignals()
+
 	// Set the shell pod running
-	log.Debug("Creating shell pod")
-	err = runExternalCommand(strings.NewReader(podJSON), append(kubectlArgs, "apply", "-f", "-")...)
+	log.Debugf("Creating shell pod %s/%s", client.namespace, shell.name)
+	err = client.createPod(pod)
 	if err != nil {
-		return 1, fmt.Errorf("failed to create shell pod: %w", err)
+		return 1, err
 	}
 
 	// Clean up when we're done
-	defer func() {
-		logrus.Info("Deleting started pod...")
-		runExternalCommand(nil, append(kubectlArgs, "delete", "--wait=false", "pod", shell.name)...)
-	}()
+	if shell.keep {
+		defer func() {
+			log.Infof("Shell pod %s is still running, `koi shell attach %s` opens a shell in it again, it is stopped after %s", shell.name, shell.name, shell.ttl)
+		}()
+	} else {
+		defer func() {
+			logrus.Info("Deleting started pod...")
+			client.deletePod(shell.name)
+		}()
+	}
 
 	log.Info("Waiting for shell pod to be ready...")
-	err = runExternalCommand(strings.NewReader(podJSON), append(kubectlArgs, "wait", "--timeout", shell.timeout.String(), "--for=condition=ready", "pod", shell.name)...)
+	err = client.waitForPod(ctx, shell.name, shell.timeout)
+	if ctx.Err() != nil {
+		return exitCodeForSignal(), nil
+	}
 	if err != nil {
-		return 1, fmt.Errorf("failed to wait for shell pod to be ready: %w", err)
+		return 1, err
 	}
 
-	if len(shell.command) == 0 {
-		log.Debug("Running default shell command")
-		command := "bash -l || sh -l"
-		if os.Getenv("KSHELL_VI_MODE") == "true" {
-			command = "bash -o vi -l || sh -l"
-		}
-		shell.command = []string{"sh", "-c", command}
+	exitCode, err = client.exec(ctx, shell.name, shell.command)
+	if ctx.Err() != nil {
+		return exitCodeForSignal(), nil
 	}
+	return exitCode, err
+}
 
-	execCommand := append(kubectlArgs, "exec", "-it", "-c", "shell", "pod/"+shell.name, "--")
-	execCommand = append(execCommand, shell.command...)
-	err = runExternalCommand(nil, execCommand...)
-	if err != nil {
-		return 1, fmt.Errorf("failed to exec into shell pod: %w", err)
+// defaultShellCommand is a login shell, bash if the image has it
+func defaultShellCommand(settings Settings) []string {
+	command := "bash -l || sh -l"
+	if settings.Shell.ViMode {
+		command = "bash -o vi -l || sh -l"
 	}
-
-	return
+	return []string{"sh", "-c", command}
 }
 
 func runExternalCommand(stdin io.Reader, command ...string) error {
@@ -139,8 +325,11 @@ func defaultEnv(key string, def string) string {
 	return val
 }
 
-func getPodJSON(config ShellInvocation) (string, error) {
-	// Generate the pod object
+// breakGlassAnnotation holds the reason for the shell, admission controllers can require it
+const breakGlassAnnotation = "admission.stackrox.io/break-glass"
+
+// shellPod returns the pod the shell runs in
+func shellPod(config ShellInvocation) (k8sv1.Pod, error) {
 	retObj := k8sv1.Pod{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Pod",
@@ -149,7 +338,7 @@ func getPodJSON(config ShellInvocation) (string, error) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name: config.name,
 			Annotations: map[string]string{
-				"admission.stackrox.io/break-glass": config.reason,
+				breakGlassAnnotation: config.reason,
 			},
 		},
 		Spec: k8sv1.PodSpec{
@@ -166,11 +355,111 @@ func getPodJSON(config ShellInvocation) (string, error) {
 			},
 		},
 	}
+	if from := config.fromTemplate; from != nil {
+		// A copy, the owner labels below would otherwise be added to the template
+		retObj.Labels = maps.Clone(from.Labels)
+		for key, value := range from.Annotations {
+			if _, ok := retObj.Annotations[key]; !ok {
+				retObj.Annotations[key] = value
+			}
+		}
+		retObj.Spec = *from.Spec.DeepCopy()
+		if config.image != "" {
+			retObj.Spec.Containers[0].Image = config.image
+		}
+	}
+
+	applyShellTemplate(&retObj, config.template)
+	if retObj.Labels == nil {
+		retObj.Labels = map[string]string{}
+	}
+	for key, value := range shellOwnerLabels(config) {
+		retObj.Labels[key] = value
+	}
 
-	// convert to json and return
-	ret, err := json.MarshalIndent(retObj, "", "  ")
+	spec := &retObj.Spec
+	container := &spec.Containers[0]
+	if config.ttl > 0 {
+		deadline := int64(config.ttl.Seconds())
+		spec.ActiveDeadlineSeconds = &deadline
+	}
+	if config.serviceAccount != "" {
+		spec.ServiceAccountName = config.serviceAccount
+	}
+	env, err := parseEnvVars(config.env)
+	if err != nil {
+		return retObj, err
+	}
+	container.Env = append(container.Env, env...)
+	err = setResources(&container.Resources.Requests, config.requests)
 	if err != nil {
-		return "", fmt.Errorf("Failed to marshal pod object: %w", err)
+		return retObj, err
+	}
+	err = setResources(&container.Resources.Limits, config.limits)
+	if err != nil {
+		return retObj, err
+	}
+
+	if config.node != "" {
+		spec.NodeName = config.node
+	}
+	spec.HostNetwork = spec.HostNetwork || config.hostNetwork || config.nodeShell
+	spec.HostPID = spec.HostPID || config.hostPID || config.nodeShell
+	spec.HostIPC = spec.HostIPC || config.nodeShell
+	if spec.HostNetwork && spec.DNSPolicy == "" {
+		// Cluster DNS still works on the node's network
+		spec.DNSPolicy = k8sv1.DNSClusterFirstWithHostNet
+	}
+	if config.privileged || config.nodeShell {
+		privileged := true
+		if container.SecurityContext == nil {
+			container.SecurityContext = &k8sv1.SecurityContext{}
+		}
+		container.SecurityContext.Privileged = &privileged
+	}
+	if config.nodeShell {
+		spec.Volumes = append(spec.Volumes, k8sv1.Volume{
+			Name:         "host",
+			VolumeSource: k8sv1.VolumeSource{HostPath: &k8sv1.HostPathVolumeSource{Path: "/"}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, k8sv1.VolumeMount{Name: "host", MountPath: "/host"})
+	}
+
+	tolerations := config.tolerations
+	if config.node != "" {
+		tolerations = append(tolerations, "*")
+	}
+	for _, toleration := range tolerations {
+		parsed, err := parseToleration(toleration)
+		if err != nil {
+			return retObj, err
+		}
+		spec.Tolerations = append(spec.Tolerations, parsed)
+	}
+	return retObj, nil
+}
+
+// parseToleration parses KEY[=VALUE][:EFFECT], or * to tolerate every taint
+func parseToleration(toleration string) (k8sv1.Toleration, error) {
+	if toleration == "*" {
+		return k8sv1.Toleration{Operator: k8sv1.TolerationOpExists}, nil
+	}
+
+	keyValue, effect, _ := strings.Cut(toleration, ":")
+	key, value, hasValue := strings.Cut(keyValue, "=")
+	ret := k8sv1.Toleration{Key: key, Operator: k8sv1.TolerationOpExists, Effect: k8sv1.TaintEffect(effect)}
+	if hasValue {
+		ret.Operator = k8sv1.TolerationOpEqual
+		ret.Value = value
+	}
+
+	if key == "" {
+		return ret, fmt.Errorf("toleration %q has no key, use KEY[=VALUE][:EFFECT] or *", toleration)
+	}
+	switch ret.Effect {
+	case "", k8sv1.TaintEffectNoSchedule, k8sv1.TaintEffectPreferNoSchedule, k8sv1.TaintEffectNoExecute:
+	default:
+		return ret, fmt.Errorf("toleration %q has an unknown effect, use NoSchedule, PreferNoSchedule or NoExecute", toleration)
 	}
-	return string(ret), nil
+	return ret, nil
 }
@@ -0,0 +1,359 @@
+package koi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+	k8sv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// shellRequestTimeout is how long creating or deleting the shell pod can take, koi may be exiting because the cluster is unreachable
+const shellRequestTimeout = 10 * time.Second
+
+// shellFailureReasons are the reasons a container waits for which the shell pod will not recover from by itself
+var shellFailureReasons = []string{
+	"ImagePullBackOff",
+	"ErrImageNeverPull",
+	"InvalidImageName",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+	"CrashLoopBackOff",
+	"RunContainerError",
+}
+
+// shellClient runs the shell pod with client-go, so koi shell does not need kubectl
+type shellClient struct {
+	client     kubernetes.Interface
+	restConfig *rest.Config
+	namespace  string
+}
+
+// newShellClient connects to the cluster of conn, the namespace is the kubeconfig's when it is empty
+func newShellClient(conn KubeConnection, namespace string) (*shellClient, error) {
+	restConfig, err := getKubeRestConfig(conn)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating clientset: %w", err)
+	}
+	if namespace == "" {
+		namespace, err = kubeconfigNamespace(conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &shellClient{client: client, restConfig: restConfig, namespace: namespace}, nil
+}
+
+func (c *shellClient) createPod(pod k8sv1.Pod) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shellRequestTimeout)
+	defer cancel()
+	_, err := c.client.CoreV1().Pods(c.namespace).Create(ctx, &pod, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("a pod called %s already exists in namespace %s, pick another --name", pod.Name, c.namespace)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create shell pod: %w", err)
+	}
+	return nil
+}
+
+func (c *shellClient) deletePod(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), shellRequestTimeout)
+	defer cancel()
+	err := c.client.CoreV1().Pods(c.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("Failed to delete shell pod %s/%s, `koi shell gc` cleans it up later: %v", c.namespace, name, err)
+	}
+}
+
+// waitForPod watches the pod until it is ready, the pod's events, such as its image being pulled, are logged as they come
+// It gives up early when the pod cannot start, eg: the image does not exist, or when ctx is cancelled
+func (c *shellClient) waitForPod(ctx context.Context, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	events, err := c.client.CoreV1().Events(c.namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": name}.String(),
+	})
+	if err != nil {
+		log.Debugf("Could not watch the events of pod %s: %v", name, err)
+	} else {
+		defer events.Stop()
+		go logPodEvents(events)
+	}
+
+	return c.watchPodUntil(ctx, name, shellPodReady, func(last *k8sv1.Pod) error {
+		return podTimeoutError(name, timeout, last)
+	})
+}
+
+// watchPodUntil watches the pod until ready returns true or an error, the watch is started again if it ends early
+// timedOut is the error once ctx is done, given the last version of the pod seen, if any
+func (c *shellClient) watchPodUntil(ctx context.Context, name string, ready podReadyFunc, timedOut func(last *k8sv1.Pod) error) error {
+	var last *k8sv1.Pod
+	for {
+		pods, err := c.client.CoreV1().Pods(c.namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return timedOut(last)
+			}
+			return fmt.Errorf("failed to watch pod %s: %w", name, err)
+		}
+		done, err := watchUntilReady(ctx, pods, ready, &last)
+		pods.Stop()
+		if err != nil || done {
+			return err
+		}
+		// The watch ended before the pod was ready, it is started again unless the timeout is up
+		if ctx.Err() != nil {
+			return timedOut(last)
+		}
+	}
+}
+
+// podReadyFunc returns true once the pod is ready for the shell, and an error if it never will be
+type podReadyFunc func(pod *k8sv1.Pod) (bool, error)
+
+// watchUntilReady reads the pod watch until the pod is ready, it cannot start, or the watch ends
+func watchUntilReady(ctx context.Context, pods watch.Interface, ready podReadyFunc, last **k8sv1.Pod) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case event, ok := <-pods.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			if event.Type == watch.Deleted {
+				return false, fmt.Errorf("pod was deleted while the shell was starting")
+			}
+			pod, ok := event.Object.(*k8sv1.Pod)
+			if !ok {
+				continue
+			}
+			*last = pod
+			done, err := ready(pod)
+			if err != nil || done {
+				return done, err
+			}
+		}
+	}
+}
+
+func logPodEvents(events watch.Interface) {
+	for event := range events.ResultChan() {
+		if event.Type != watch.Added && event.Type != watch.Modified {
+			continue
+		}
+		podEvent, ok := event.Object.(*k8sv1.Event)
+		if !ok {
+			continue
+		}
+		if podEvent.Type == k8sv1.EventTypeWarning {
+			log.Warnf("%s: %s", podEvent.Reason, podEvent.Message)
+		} else {
+			log.Infof("%s: %s", podEvent.Reason, podEvent.Message)
+		}
+	}
+}
+
+// shellPodReady returns true once the pod is ready, and an error if it stopped or its containers cannot start
+func shellPodReady(pod *k8sv1.Pod) (bool, error) {
+	if pod.Status.Phase == k8sv1.PodSucceeded || pod.Status.Phase == k8sv1.PodFailed {
+		return false, fmt.Errorf("shell pod %s stopped: %s", pod.Name, strings.TrimSpace(fmt.Sprintf("%s %s %s", pod.Status.Phase, pod.Status.Reason, pod.Status.Message)))
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if waiting := status.State.Waiting; waiting != nil && stringArrayContains(shellFailureReasons, waiting.Reason) {
+			return false, fmt.Errorf("container %s of shell pod %s cannot start: %s: %s", status.Name, pod.Name, waiting.Reason, waiting.Message)
+		}
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == k8sv1.PodReady && condition.Status == k8sv1.ConditionTrue {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// podTimeoutError says why the pod was not ready in time, eg: it could not be scheduled
+func podTimeoutError(name string, timeout time.Duration, pod *k8sv1.Pod) error {
+	if pod == nil {
+		return fmt.Errorf("shell pod %s was not ready within %s", name, timeout)
+	}
+	reasons := []string{}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status != k8sv1.ConditionTrue && condition.Message != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", condition.Type, condition.Message))
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if waiting := status.State.Waiting; waiting != nil {
+			reasons = append(reasons, strings.TrimSpace(fmt.Sprintf("container %s is waiting: %s %s", status.Name, waiting.Reason, waiting.Message)))
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("shell pod %s was not ready within %s, it is %s", name, timeout, pod.Status.Phase)
+	}
+	return fmt.Errorf("shell pod %s was not ready within %s: %s", name, timeout, strings.Join(reasons, ", "))
+}
+
+// exec runs command in the shell container and returns its exit code
+// When stdin is a terminal it is put in raw mode and the remote terminal follows its size, until the command exits or ctx is cancelled
+func (c *shellClient) exec(ctx context.Context, podName string, command []string) (exitCode int, runError error) {
+	tty := term.IsTerminal(int(os.Stdin.Fd()))
+	request := c.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(c.namespace).
+		Name(podName).
+		SubResource("exec").
+		VersionedParams(&k8sv1.PodExecOptions{
+			Container: "shell",
+			Command:   command,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    !tty,
+			TTY:       tty,
+		}, scheme.ParameterCodec)
+
+	exitCode, err := c.stream(ctx, request, tty)
+	if err != nil {
+		return exitCode, fmt.Errorf("failed to exec into shell pod: %w", err)
+	}
+	return exitCode, nil
+}
+
+// attach connects the terminal to a container which is already running the shell, eg: an ephemeral container
+func (c *shellClient) attach(ctx context.Context, podName string, containerName string) (exitCode int, runError error) {
+	tty := term.IsTerminal(int(os.Stdin.Fd()))
+	request := c.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(c.namespace).
+		Name(podName).
+		SubResource("attach").
+		VersionedParams(&k8sv1.PodAttachOptions{
+			Container: containerName,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    !tty,
+			TTY:       tty,
+		}, scheme.ParameterCodec)
+
+	exitCode, err := c.stream(ctx, request, tty)
+	if err != nil {
+		return exitCode, fmt.Errorf("failed to attach to container %s: %w", containerName, err)
+	}
+	return exitCode, nil
+}
+
+// stream connects stdin and stdout to an exec or attach request and returns the remote command's exit code
+// With tty the terminal is put in raw mode and the remote terminal follows its size
+func (c *shellClient) stream(ctx context.Context, request *rest.Request, tty bool) (exitCode int, runError error) {
+	executor, err := newExecutor(c.restConfig, request)
+	if err != nil {
+		return 1, err
+	}
+
+	options := remotecommand.StreamOptions{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Tty:    tty,
+	}
+	if tty {
+		stdinFd := int(os.Stdin.Fd())
+		state, err := term.MakeRaw(stdinFd)
+		if err != nil {
+			return 1, fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+		}
+		defer term.Restore(stdinFd, state)
+
+		sizes := newTerminalSizeQueue(stdinFd)
+		defer sizes.stop()
+		options.TerminalSizeQueue = sizes
+	} else {
+		options.Stderr = os.Stderr
+	}
+
+	err = executor.StreamWithContext(ctx, options)
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// newExecutor streams over websockets, falling back to SPDY for API servers which do not support them, like kubectl
+func newExecutor(restConfig *rest.Config, request *rest.Request) (remotecommand.Executor, error) {
+	spdy, err := remotecommand.NewSPDYExecutor(restConfig, "POST", request.URL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SPDY executor: %w", err)
+	}
+	websocket, err := remotecommand.NewWebSocketExecutor(restConfig, "GET", request.URL().String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create websocket executor: %w", err)
+	}
+	return remotecommand.NewFallbackExecutor(websocket, spdy, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+}
+
+// terminalSizeQueue sends the terminal's size when the shell starts and each time the terminal is resized
+type terminalSizeQueue struct {
+	fd      int
+	resized chan os.Signal
+	done    chan struct{}
+}
+
+func newTerminalSizeQueue(fd int) *terminalSizeQueue {
+	q := &terminalSizeQueue{fd: fd, resized: make(chan os.Signal, 1), done: make(chan struct{})}
+	signal.Notify(q.resized, syscall.SIGWINCH)
+	// The first size is the terminal's size as it is now
+	q.resized <- syscall.SIGWINCH
+	return q
+}
+
+func (q *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	for {
+		select {
+		case <-q.done:
+			return nil
+		case <-q.resized:
+		}
+		width, height, err := term.GetSize(q.fd)
+		if err != nil {
+			log.Debugf("Could not get the terminal size: %v", err)
+			continue
+		}
+		return &remotecommand.TerminalSize{Width: uint16(width), Height: uint16(height)}
+	}
+}
+
+func (q *terminalSizeQueue) stop() {
+	signal.Stop(q.resized)
+	close(q.done)
+}
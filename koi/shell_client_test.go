@@ -0,0 +1,120 @@
+package koi
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_shellPodReady(t *testing.T) {
+	newPod := func(phase k8sv1.PodPhase, ready bool, waiting string) *k8sv1.Pod {
+		pod := &k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1"}, Status: k8sv1.PodStatus{Phase: phase}}
+		if ready {
+			pod.Status.Conditions = []k8sv1.PodCondition{{Type: k8sv1.PodReady, Status: k8sv1.ConditionTrue}}
+		}
+		if waiting != "" {
+			pod.Status.ContainerStatuses = []k8sv1.ContainerStatus{{
+				Name:  "shell",
+				State: k8sv1.ContainerState{Waiting: &k8sv1.ContainerStateWaiting{Reason: waiting, Message: "back-off pulling image"}},
+			}}
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name    string
+		pod     *k8sv1.Pod
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "A pending pod should be waited for",
+			pod:  newPod(k8sv1.PodPending, false, "ContainerCreating"),
+			want: false,
+		},
+		{
+			name: "A ready pod should be ready",
+			pod:  newPod(k8sv1.PodRunning, true, ""),
+			want: true,
+		},
+		{
+			name:    "An image which cannot be pulled should be an error",
+			pod:     newPod(k8sv1.PodPending, false, "ImagePullBackOff"),
+			wantErr: true,
+		},
+		{
+			name:    "A stopped pod should be an error",
+			pod:     newPod(k8sv1.PodFailed, false, ""),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := shellPodReady(tt.pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("shellPodReady() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("shellPodReady() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newWatchedShellClient returns a client whose pod watches send the given pods
+func newWatchedShellClient(pods ...*k8sv1.Pod) *shellClient {
+	clientset := fake.NewClientset()
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFakeWithChanSize(len(pods), false)
+		for _, pod := range pods {
+			watcher.Modify(pod)
+		}
+		return true, watcher, nil
+	})
+	return &shellClient{client: clientset, namespace: "web"}
+}
+
+func Test_shellClient_waitForPod(t *testing.T) {
+	pending := &k8sv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1"},
+		Status: k8sv1.PodStatus{
+			Phase:      k8sv1.PodPending,
+			Conditions: []k8sv1.PodCondition{{Type: k8sv1.PodScheduled, Status: k8sv1.ConditionFalse, Message: "0/3 nodes are available"}},
+		},
+	}
+	ready := pending.DeepCopy()
+	ready.Status.Phase = k8sv1.PodRunning
+	ready.Status.Conditions = []k8sv1.PodCondition{{Type: k8sv1.PodReady, Status: k8sv1.ConditionTrue}}
+
+	err := newWatchedShellClient(pending, ready).waitForPod(context.Background(), "bob-shell-1", time.Second)
+	if err != nil {
+		t.Errorf("waitForPod() error = %v", err)
+	}
+
+	err = newWatchedShellClient(pending).waitForPod(context.Background(), "bob-shell-1", 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "0/3 nodes are available") {
+		t.Errorf("waitForPod() should time out with why the pod is not ready, got: %v", err)
+	}
+}
+
+func Test_shellClient_createPod(t *testing.T) {
+	existing := &k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1", Namespace: "web"}}
+	client := &shellClient{client: fake.NewClientset([]runtime.Object{existing}...), namespace: "web"}
+
+	err := client.createPod(k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-2"}})
+	if err != nil {
+		t.Errorf("createPod() error = %v", err)
+	}
+	err = client.createPod(k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1"}})
+	if err == nil || !strings.Contains(err.Error(), "--name") {
+		t.Errorf("createPod() of a pod which exists should ask for another --name, got: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package koi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// controllerLabels are added to pods by their controllers, a copy of a pod keeping them could be adopted by its owner
+var controllerLabels = []string{
+	"pod-template-hash",
+	"controller-revision-hash",
+	"statefulset.kubernetes.io/pod-name",
+	"apps.kubernetes.io/pod-index",
+	"controller-uid",
+	"job-name",
+	"batch.kubernetes.io/controller-uid",
+	"batch.kubernetes.io/job-name",
+	"batch.kubernetes.io/job-completion-index",
+}
+
+// workloadPodTemplate returns the pod template of --from, eg: deployment/api, turned into a shell pod's
+// The app container keeps its image, env, mounts and service account, but sleeps instead of running the app
+func workloadPodTemplate(client *shellClient, from string, containerName string) (k8sv1.PodTemplateSpec, error) {
+	kind, name, found := strings.Cut(from, "/")
+	if !found || name == "" {
+		return k8sv1.PodTemplateSpec{}, fmt.Errorf("--from must be KIND/NAME, eg: deployment/api, not %q", from)
+	}
+	kind = normalizeKind(kind)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shellRequestTimeout)
+	defer cancel()
+	template, selector, err := getPodTemplate(ctx, client, kind, name)
+	if err != nil {
+		return template, err
+	}
+
+	selectors := []*metav1.LabelSelector{}
+	if selector != nil {
+		selectors = append(selectors, selector)
+	}
+	services, err := serviceSelectors(ctx, client)
+	if err != nil {
+		// Keeping a label which puts the shell behind a service is worse than losing them all
+		log.Warnf("Could not list the services, the shell pod will have no labels: %v", err)
+		template.Labels = nil
+	}
+	template.Labels = detachLabels(template.Labels, append(selectors, services...))
+
+	return shellFromPodTemplate(template, containerName)
+}
+
+// getPodTemplate returns the pod template of a workload, and the selector its controller adopts pods with
+func getPodTemplate(ctx context.Context, client *shellClient, kind string, name string) (k8sv1.PodTemplateSpec, *metav1.LabelSelector, error) {
+	var template k8sv1.PodTemplateSpec
+	var selector *metav1.LabelSelector
+	var err error
+	options := metav1.GetOptions{}
+	switch kind {
+	case "Pod":
+		var pod *k8sv1.Pod
+		pod, err = client.client.CoreV1().Pods(client.namespace).Get(ctx, name, options)
+		if err == nil {
+			template = podTemplateFromPod(*pod)
+		}
+	case "Deployment":
+		var workload *appsv1.Deployment
+		workload, err = client.client.AppsV1().Deployments(client.namespace).Get(ctx, name, options)
+		if err == nil {
+			template, selector = workload.Spec.Template, workload.Spec.Selector
+		}
+	case "StatefulSet":
+		var workload *appsv1.StatefulSet
+		workload, err = client.client.AppsV1().StatefulSets(client.namespace).Get(ctx, name, options)
+		if err == nil {
+			template, selector = workload.Spec.Template, workload.Spec.Selector
+		}
+	case "DaemonSet":
+		var workload *appsv1.DaemonSet
+		workload, err = client.client.AppsV1().DaemonSets(client.namespace).Get(ctx, name, options)
+		if err == nil {
+			template, selector = workload.Spec.Template, workload.Spec.Selector
+		}
+	case "ReplicaSet":
+		var workload *appsv1.ReplicaSet
+		workload, err = client.client.AppsV1().ReplicaSets(client.namespace).Get(ctx, name, options)
+		if err == nil {
+			template, selector = workload.Spec.Template, workload.Spec.Selector
+		}
+	case "Job":
+		var workload *batchv1.Job
+		workload, err = client.client.BatchV1().Jobs(client.namespace).Get(ctx, name, options)
+		if err == nil {
+			template, selector = workload.Spec.Template, workload.Spec.Selector
+		}
+	case "CronJob":
+		var workload *batchv1.CronJob
+		workload, err = client.client.BatchV1().CronJobs(client.namespace).Get(ctx, name, options)
+		if err == nil {
+			template = workload.Spec.JobTemplate.Spec.Template
+		}
+	default:
+		return template, nil, fmt.Errorf("a shell cannot be started from a %s, use a deployment, statefulset, daemonset, replicaset, job, cronjob or pod", kind)
+	}
+	if err != nil {
+		return template, nil, fmt.Errorf("failed to get %s %s: %w", strings.ToLower(kind), name, err)
+	}
+	return template, selector, nil
+}
+
+// podTemplateFromPod keeps only what the pod was started with, not its owners or where it was scheduled
+func podTemplateFromPod(pod k8sv1.Pod) k8sv1.PodTemplateSpec {
+	template := k8sv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: pod.Labels, Annotations: pod.Annotations},
+		Spec:       pod.Spec,
+	}
+	template.Spec.NodeName = ""
+	template.Spec.Hostname = ""
+	template.Spec.Subdomain = ""
+	template.Spec.EphemeralContainers = nil
+	for _, key := range controllerLabels {
+		delete(template.Labels, key)
+	}
+	return template
+}
+
+// serviceSelectors returns the selectors of the services in the shell's namespace
+func serviceSelectors(ctx context.Context, client *shellClient) ([]*metav1.LabelSelector, error) {
+	services, err := client.client.CoreV1().Services(client.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ret := []*metav1.LabelSelector{}
+	for _, service := range services.Items {
+		if len(service.Spec.Selector) > 0 {
+			ret = append(ret, &metav1.LabelSelector{MatchLabels: service.Spec.Selector})
+		}
+	}
+	return ret, nil
+}
+
+// detachLabels returns podLabels without the labels of every selector which matches them
+// so the shell pod gets no traffic from services and is not adopted by the workload's controller
+func detachLabels(podLabels map[string]string, selectors []*metav1.LabelSelector) map[string]string {
+	ret := map[string]string{}
+	for key, value := range podLabels {
+		ret[key] = value
+	}
+	for _, selector := range selectors {
+		parsed, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil || parsed.Empty() || !parsed.Matches(labels.Set(podLabels)) {
+			continue
+		}
+		for key := range selector.MatchLabels {
+			delete(ret, key)
+		}
+		for _, expression := range selector.MatchExpressions {
+			delete(ret, expression.Key)
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// shellFromPodTemplate keeps only the app container of template, named shell, and makes it sleep instead of running the app
+// Init containers and sidecars are dropped so none of the app's code runs, eg: a migration
+func shellFromPodTemplate(template k8sv1.PodTemplateSpec, containerName string) (k8sv1.PodTemplateSpec, error) {
+	template = *template.DeepCopy()
+	name, err := targetContainer(k8sv1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}, containerName)
+	if err != nil {
+		return template, err
+	}
+
+	var container k8sv1.Container
+	for _, c := range template.Spec.Containers {
+		if c.Name == name {
+			container = c
+		}
+	}
+	container.Name = "shell"
+	container.Command = []string{"/bin/sh", "-c", "sleep 1000000"}
+	container.Args = nil
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.StartupProbe = nil
+	container.Lifecycle = nil
+	// The shell serves nothing, and a host port could stop it being scheduled next to the app
+	container.Ports = nil
+
+	template.Spec.Containers = []k8sv1.Container{container}
+	template.Spec.InitContainers = nil
+	delete(template.Annotations, defaultContainerAnnotation)
+	return template, nil
+}
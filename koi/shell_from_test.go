@@ -0,0 +1,228 @@
+package koi
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_workloadPodTemplate(t *testing.T) {
+	apiPod := k8sv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "api", "team": "payments", "tier": "web"}},
+		Spec:       k8sv1.PodSpec{Containers: []k8sv1.Container{{Name: "api", Image: "api:1"}}},
+	}
+	client := &shellClient{
+		namespace: "web",
+		client: fake.NewClientset(
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "web"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+					Template: apiPod,
+				},
+			},
+			&batchv1.CronJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "web"},
+				Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: k8sv1.PodTemplateSpec{
+					Spec: k8sv1.PodSpec{Containers: []k8sv1.Container{{Name: "report", Image: "report:1"}}},
+				}}}},
+			},
+			&k8sv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "api-abc-1",
+					Namespace:       "web",
+					Labels:          map[string]string{"team": "payments", "pod-template-hash": "abc"},
+					OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "api-abc"}},
+				},
+				Spec: k8sv1.PodSpec{NodeName: "node-1", Containers: []k8sv1.Container{{Name: "api", Image: "api:1"}}},
+			},
+			&k8sv1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "web"},
+				Spec:       k8sv1.ServiceSpec{Selector: map[string]string{"team": "payments"}},
+			},
+		),
+	}
+
+	tests := []struct {
+		name       string
+		from       string
+		wantImage  string
+		wantLabels map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "A deployment should lose the labels of its selector and services",
+			from:       "deploy/api",
+			wantImage:  "api:1",
+			wantLabels: map[string]string{"tier": "web"},
+		},
+		{
+			name:      "A cronjob's template should be inside its job template",
+			from:      "cronjob/report",
+			wantImage: "report:1",
+		},
+		{
+			name:      "A pod should lose its controller's labels and where it ran",
+			from:      "pod/api-abc-1",
+			wantImage: "api:1",
+		},
+		{
+			name:    "A workload which does not exist should be an error",
+			from:    "statefulset/db",
+			wantErr: true,
+		},
+		{
+			name:    "Other kinds should be an error",
+			from:    "service/payments",
+			wantErr: true,
+		},
+		{
+			name:    "A kind is needed",
+			from:    "api",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := workloadPodTemplate(client, tt.from, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("workloadPodTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Spec.Containers[0].Image != tt.wantImage {
+				t.Errorf("workloadPodTemplate() image got: %v, want: %v", got.Spec.Containers[0].Image, tt.wantImage)
+			}
+			if !reflect.DeepEqual(got.Labels, tt.wantLabels) {
+				t.Errorf("workloadPodTemplate() labels got: %v, want: %v", got.Labels, tt.wantLabels)
+			}
+			if got.Spec.NodeName != "" || len(got.OwnerReferences) > 0 {
+				t.Errorf("workloadPodTemplate() should not keep the node or owners, got: %+v", got)
+			}
+		})
+	}
+}
+
+func Test_detachLabels(t *testing.T) {
+	podLabels := map[string]string{"app": "api", "tier": "web", "team": "payments"}
+	tests := []struct {
+		name      string
+		selectors []*metav1.LabelSelector
+		want      map[string]string
+	}{
+		{
+			name:      "The labels of a matching selector should be removed",
+			selectors: []*metav1.LabelSelector{{MatchLabels: map[string]string{"app": "api", "tier": "web"}}},
+			want:      map[string]string{"team": "payments"},
+		},
+		{
+			name:      "A selector which does not match should keep the labels",
+			selectors: []*metav1.LabelSelector{{MatchLabels: map[string]string{"app": "worker"}}},
+			want:      podLabels,
+		},
+		{
+			name: "Match expressions should be removed too",
+			selectors: []*metav1.LabelSelector{{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: metav1.LabelSelectorOpExists}},
+			}},
+			want: map[string]string{"app": "api", "tier": "web"},
+		},
+		{
+			name: "Every label can be removed",
+			selectors: []*metav1.LabelSelector{
+				{MatchLabels: map[string]string{"app": "api"}},
+				{MatchLabels: map[string]string{"tier": "web", "team": "payments"}},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detachLabels(podLabels, tt.selectors); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("detachLabels() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shellFromPodTemplate(t *testing.T) {
+	probe := &k8sv1.Probe{ProbeHandler: k8sv1.ProbeHandler{Exec: &k8sv1.ExecAction{Command: []string{"true"}}}}
+	template := k8sv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "api"},
+			Annotations: map[string]string{defaultContainerAnnotation: "api"},
+		},
+		Spec: k8sv1.PodSpec{
+			ServiceAccountName: "api",
+			InitContainers:     []k8sv1.Container{{Name: "migrate", Image: "api:1"}},
+			Containers: []k8sv1.Container{
+				{Name: "proxy", Image: "proxy:1"},
+				{
+					Name:           "api",
+					Image:          "api:1",
+					Command:        []string{"/app"},
+					Args:           []string{"serve"},
+					Env:            []k8sv1.EnvVar{{Name: "DB", Value: "db"}},
+					VolumeMounts:   []k8sv1.VolumeMount{{Name: "config", MountPath: "/config"}},
+					Ports:          []k8sv1.ContainerPort{{ContainerPort: 8080, HostPort: 8080}},
+					LivenessProbe:  probe,
+					ReadinessProbe: probe,
+					StartupProbe:   probe,
+				},
+			},
+		},
+	}
+
+	got, err := shellFromPodTemplate(template, "")
+	if err != nil {
+		t.Fatalf("shellFromPodTemplate() error = %v", err)
+	}
+	want := k8sv1.PodSpec{
+		ServiceAccountName: "api",
+		Containers: []k8sv1.Container{{
+			Name:         "shell",
+			Image:        "api:1",
+			Command:      []string{"/bin/sh", "-c", "sleep 1000000"},
+			Env:          []k8sv1.EnvVar{{Name: "DB", Value: "db"}},
+			VolumeMounts: []k8sv1.VolumeMount{{Name: "config", MountPath: "/config"}},
+		}},
+	}
+	if !reflect.DeepEqual(got.Spec, want) {
+		t.Errorf("shellFromPodTemplate() spec got: %+v, want: %+v", got.Spec, want)
+	}
+	if len(template.Spec.Containers) != 2 {
+		t.Errorf("shellFromPodTemplate() should not change the template")
+	}
+
+	// The shell pod gets the template's labels, its owner labels should not be added to the template
+	pod, err := shellPod(ShellInvocation{user: "bob", fromTemplate: &got})
+	if err != nil {
+		t.Fatalf("shellPod() error = %v", err)
+	}
+	if pod.Labels["app"] != "api" || pod.Labels[shellUserLabel] != "bob" {
+		t.Errorf("shellPod() labels got: %v, want the template's and the owner's", pod.Labels)
+	}
+	wantLabels := map[string]string{"app": "api"}
+	if !reflect.DeepEqual(got.Labels, wantLabels) || !reflect.DeepEqual(template.Labels, wantLabels) {
+		t.Errorf("shellPod() should not change the template labels, got: %v, want: %v", got.Labels, wantLabels)
+	}
+
+	got, err = shellFromPodTemplate(template, "proxy")
+	if err != nil {
+		t.Fatalf("shellFromPodTemplate() error = %v", err)
+	}
+	if got.Spec.Containers[0].Image != "proxy:1" {
+		t.Errorf("shellFromPodTemplate() image got: %v, want: proxy:1", got.Spec.Containers[0].Image)
+	}
+
+	_, err = shellFromPodTemplate(template, "worker")
+	if err == nil {
+		t.Errorf("shellFromPodTemplate() should fail for a container the pod does not have")
+	}
+}
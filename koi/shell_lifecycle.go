@@ -0,0 +1,215 @@
+package koi
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	log "github.com/sirupsen/logrus"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// The labels on every shell pod, so shells left behind can be found and cleaned up
+const (
+	shellLabel          = "koi/shell"
+	shellUserLabel      = "koi/user"
+	shellHostLabel      = "koi/host"
+	shellCreatedAtLabel = "koi/created-at"
+	shellTTLLabel       = "koi/ttl"
+)
+
+// defaultShellTTL is how long a shell pod can live when neither --ttl nor the profile say otherwise
+const defaultShellTTL = 12 * time.Hour
+
+// invalidLabelChars are the characters a label value cannot have
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// shellOwnerLabels returns the labels saying who started the shell, where, and when
+func shellOwnerLabels(config ShellInvocation) map[string]string {
+	ret := map[string]string{
+		shellLabel:     "true",
+		shellUserLabel: labelValue(config.user),
+		shellHostLabel: labelValue(config.host),
+	}
+	if !config.createdAt.IsZero() {
+		ret[shellCreatedAtLabel] = strconv.FormatInt(config.createdAt.Unix(), 10)
+	}
+	if config.ttl > 0 {
+		ret[shellTTLLabel] = strconv.FormatInt(int64(config.ttl.Seconds()), 10)
+	}
+	return ret
+}
+
+// labelValue makes value fit in a label, eg: a host name with a space in it
+func labelValue(value string) string {
+	value = invalidLabelChars.ReplaceAllString(value, "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "._-")
+}
+
+// shellSignals are how koi is stopped, eg: Ctrl-C before the shell starts, the terminal closing or a kill
+var shellSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+// notifyShellSignals returns a context which is cancelled when koi gets one of shellSignals
+// so the shell returns through its defers, which restore the terminal and delete the pod
+// exitCode is the exit code for the signal which cancelled the context
+func notifyShellSignals() (ctx context.Context, exitCode func() int, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, shellSignals...)
+	done := make(chan struct{})
+
+	var mutex sync.Mutex
+	var got os.Signal
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warnf("Got %s, stopping the shell", sig)
+			mutex.Lock()
+			got = sig
+			mutex.Unlock()
+			cancel()
+		case <-done:
+		}
+	}()
+
+	exitCode = func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return signalExitCode(got)
+	}
+	stop = sync.OnceFunc(func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	})
+	return ctx, exitCode, stop
+}
+
+// signalExitCode is the exit code of a process killed by sig, like a shell reports it: 128 plus the signal's number
+func signalExitCode(sig os.Signal) int {
+	if number, ok := sig.(syscall.Signal); ok {
+		return 128 + int(number)
+	}
+	return 1
+}
+
+// ShellGCCommand deletes the shell pods, in every namespace, which have finished or are older than olderThan
+// These are shells whose koi was killed before it could clean up, eg: the laptop went to sleep
+func ShellGCCommand(shell *ShellInvocation, olderThan time.Duration, dryRun bool) (exitCode int, runError error) {
+	conn := shell.connection
+	conn.Context = shell.context
+	client, err := getKubeClient(conn)
+	if err != nil {
+		return 1, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shell.timeout)
+	defer cancel()
+	pods, err := listShellPods(ctx, conn, "", "")
+	if err != nil {
+		return 1, err
+	}
+
+	now := time.Now()
+	orphaned := []k8sv1.Pod{}
+	for _, pod := range pods {
+		if shellPodOrphaned(pod, now, olderThan) {
+			orphaned = append(orphaned, pod)
+		}
+	}
+	if len(orphaned) == 0 {
+		log.Infof("No shell pods have finished or are older than %s", olderThan)
+		return 0, nil
+	}
+	printShellPods(os.Stdout, orphaned, WritingToTerminal(), now)
+	if dryRun {
+		return 0, nil
+	}
+
+	failed := 0
+	for _, pod := range orphaned {
+		err := client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		if err != nil {
+			log.Errorf("Failed to delete shell pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			failed++
+		}
+	}
+	log.Infof("Deleted %d shell pods", len(orphaned)-failed)
+	return min(failed, 125), nil
+}
+
+// listShellPods returns the pods started by koi shell, in every namespace when namespace is empty
+// and only user's when user is set
+func listShellPods(ctx context.Context, conn KubeConnection, namespace string, user string) ([]k8sv1.Pod, error) {
+	selector := shellLabel + "=true"
+	if user != "" {
+		selector += "," + shellUserLabel + "=" + labelValue(user)
+	}
+	pods, err := getPodsByNamespace(ctx, conn, namespace, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+	return pods, nil
+}
+
+// shellPodOrphaned returns true if nobody can be using the shell pod any more
+func shellPodOrphaned(pod k8sv1.Pod, now time.Time, olderThan time.Duration) bool {
+	if pod.Status.Phase == k8sv1.PodSucceeded || pod.Status.Phase == k8sv1.PodFailed {
+		return true
+	}
+	return now.Sub(shellPodCreatedAt(pod)) > olderThan
+}
+
+// shellPodCreatedAt is when koi started the shell, or when the pod was created for pods without the label
+func shellPodCreatedAt(pod k8sv1.Pod) time.Time {
+	if seconds, err := strconv.ParseInt(pod.Labels[shellCreatedAtLabel], 10, 64); err == nil {
+		return time.Unix(seconds, 0)
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// printShellPods writes the pods as a table, with a context column when they have the context annotation
+func printShellPods(w io.Writer, pods []k8sv1.Pod, writeInColor bool, now time.Time) {
+	withContext := false
+	for _, pod := range pods {
+		if _, ok := pod.Annotations[contextAnnotation]; ok {
+			withContext = true
+		}
+	}
+
+	headers := []interface{}{"NAMESPACE", "NAME", "USER", "HOST", "STATUS", "AGE"}
+	if withContext {
+		headers = append([]interface{}{"CONTEXT"}, headers...)
+	}
+	tbl := table.New(headers...).WithWriter(w)
+	for _, pod := range pods {
+		row := []interface{}{pod.Namespace, pod.Name, pod.Labels[shellUserLabel], pod.Labels[shellHostLabel], pod.Status.Phase,
+			duration.HumanDuration(now.Sub(shellPodCreatedAt(pod)))}
+		if withContext {
+			row = append([]interface{}{pod.Annotations[contextAnnotation]}, row...)
+		}
+		tbl.AddRow(row...)
+	}
+	if writeInColor {
+		tbl.WithHeaderFormatter(color.New(color.FgHiWhite, color.Underline).SprintfFunc())
+	}
+	tbl.Print()
+}
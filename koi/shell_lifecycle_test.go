@@ -0,0 +1,123 @@
+package koi
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_shellOwnerLabels(t *testing.T) {
+	shell := ShellInvocation{
+		user:      "bob",
+		host:      "Bob's MacBook Pro.local",
+		createdAt: time.Unix(1700000000, 0),
+		ttl:       12 * time.Hour,
+	}
+	want := map[string]string{
+		shellLabel:          "true",
+		shellUserLabel:      "bob",
+		shellHostLabel:      "Bob-s-MacBook-Pro.local",
+		shellCreatedAtLabel: "1700000000",
+		shellTTLLabel:       "43200",
+	}
+	if got := shellOwnerLabels(shell); !reflect.DeepEqual(got, want) {
+		t.Errorf("shellOwnerLabels() got: %v, want: %v", got, want)
+	}
+
+	pod, err := shellPod(shell)
+	if err != nil {
+		t.Fatalf("shellPod() error = %v", err)
+	}
+	if deadline := pod.Spec.ActiveDeadlineSeconds; deadline == nil || *deadline != 43200 {
+		t.Errorf("shellPod() activeDeadlineSeconds got: %v, want: 43200", deadline)
+	}
+}
+
+func Test_labelValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "A valid value should be kept", value: "bob.smith_1", want: "bob.smith_1"},
+		{name: "Invalid characters should be replaced", value: "DOMAIN\\bob smith", want: "DOMAIN-bob-smith"},
+		{name: "The ends should be alphanumeric", value: "-bob.", want: "bob"},
+		{name: "Long values should be cut", value: "a234567890123456789012345678901234567890123456789012345678901234567890", want: "a23456789012345678901234567890123456789012345678901234567890123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labelValue(tt.value); got != tt.want {
+				t.Errorf("labelValue() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shellPodOrphaned(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	newPod := func(age time.Duration, phase k8sv1.PodPhase, labels map[string]string) k8sv1.Pod {
+		return k8sv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-age)), Labels: labels},
+			Status:     k8sv1.PodStatus{Phase: phase},
+		}
+	}
+
+	tests := []struct {
+		name string
+		pod  k8sv1.Pod
+		want bool
+	}{
+		{
+			name: "A new running shell should be kept",
+			pod:  newPod(time.Hour, k8sv1.PodRunning, nil),
+			want: false,
+		},
+		{
+			name: "An old running shell should be deleted",
+			pod:  newPod(25*time.Hour, k8sv1.PodRunning, nil),
+			want: true,
+		},
+		{
+			name: "A shell stopped by its deadline should be deleted",
+			pod:  newPod(time.Hour, k8sv1.PodFailed, nil),
+			want: true,
+		},
+		{
+			name: "The created-at label should be used before the creation time",
+			pod:  newPod(time.Hour, k8sv1.PodRunning, map[string]string{shellCreatedAtLabel: "1600000000"}),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shellPodOrphaned(tt.pod, now, 24*time.Hour); got != tt.want {
+				t.Errorf("shellPodOrphaned() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_signalExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		sig  os.Signal
+		want int
+	}{
+		{name: "Ctrl-C should exit with 130", sig: os.Interrupt, want: 130},
+		{name: "A kill should exit with 143", sig: syscall.SIGTERM, want: 143},
+		{name: "Closing the terminal should exit with 129", sig: syscall.SIGHUP, want: 129},
+		{name: "No signal should be a failure", sig: nil, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signalExitCode(tt.sig); got != tt.want {
+				t.Errorf("signalExitCode() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package koi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// ShellListOptions are the flags of `koi shell ls`
+type ShellListOptions struct {
+	contexts    string
+	allContexts bool
+	allUsers    bool
+}
+
+// ShellListCommand lists the shell pods you started, in every namespace of the context or of several contexts
+func ShellListCommand(shell *ShellInvocation, opts ShellListOptions) (exitCode int, runError error) {
+	conn := shell.connection
+	conn.Context = shell.context
+	user := defaultEnv("USER", "koi")
+	if opts.allUsers {
+		user = ""
+	}
+
+	contexts := []string{conn.Context}
+	if opts.contexts != "" || opts.allContexts {
+		available, err := listKubeContexts(conn)
+		if err != nil {
+			return 1, err
+		}
+		contexts = available
+		if !opts.allContexts {
+			contexts, err = matchContexts(opts.contexts, available)
+			if err != nil {
+				return 1, err
+			}
+		}
+	}
+
+	pods, failed := listShellPodsInContexts(conn, contexts, user, shell.timeout)
+	if len(pods) == 0 && failed < len(contexts) {
+		log.Info("No shell pods found")
+	}
+	if len(pods) > 0 {
+		printShellPods(os.Stdout, pods, WritingToTerminal(), time.Now())
+	}
+	return min(failed, 125), nil
+}
+
+// listShellPodsInContexts lists the shell pods in every context at the same time
+// With several contexts each pod gets the context annotation, a context which cannot be reached is a warning
+func listShellPodsInContexts(conn KubeConnection, contexts []string, user string, timeout time.Duration) (pods []k8sv1.Pod, failed int) {
+	results := make([][]k8sv1.Pod, len(contexts))
+	errs := make([]error, len(contexts))
+	var wg sync.WaitGroup
+	for i, kubeContext := range contexts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			contextConn := conn
+			contextConn.Context = kubeContext
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			results[i], errs[i] = listShellPods(ctx, contextConn, "", user)
+		}()
+	}
+	wg.Wait()
+
+	for i, kubeContext := range contexts {
+		if errs[i] != nil {
+			log.Warnf("Could not list shell pods in context %q: %v", kubeContext, errs[i])
+			failed++
+			continue
+		}
+		for _, pod := range results[i] {
+			if len(contexts) > 1 {
+				if pod.Annotations == nil {
+					pod.Annotations = map[string]string{}
+				}
+				pod.Annotations[contextAnnotation] = kubeContext
+			}
+			pods = append(pods, pod)
+		}
+	}
+	return pods, failed
+}
+
+// ShellAttachCommand opens a new shell in a shell pod which is still running, eg: one started with --keep
+// The pod is found by name in every namespace, it is left running when the shell exits
+func ShellAttachCommand(settings Settings, shell *ShellInvocation, name string) (exitCode int, runError error) {
+	conn := shell.connection
+	conn.Context = shell.context
+
+	ctx, cancel := context.WithTimeout(context.Background(), shell.timeout)
+	defer cancel()
+	pods, err := getPodsByNamespace(ctx, conn, "", metav1.ListOptions{
+		LabelSelector: shellLabel + "=true",
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+	if err != nil {
+		return 1, err
+	}
+	pod, err := pickShellPod(pods, name, shell.namespace)
+	if err != nil {
+		return 1, err
+	}
+	if pod.Status.Phase != k8sv1.PodRunning {
+		return 1, fmt.Errorf("shell pod %s/%s is %s, it cannot be attached to", pod.Namespace, pod.Name, pod.Status.Phase)
+	}
+
+	command := shell.command
+	if len(command) == 0 {
+		command = defaultShellCommand(settings)
+	}
+	client, err := newShellClient(conn, pod.Namespace)
+	if err != nil {
+		return 1, err
+	}
+	shellCtx, exitCodeForSignal, stopSignals := notifyShellSignals()
+	defer stopSignals()
+	exitCode, err = client.exec(shellCtx, pod.Name, command)
+	if shellCtx.Err() != nil {
+		exitCode, err = exitCodeForSignal(), nil
+	}
+	log.Infof("Shell pod %s is still running, `kubectl delete pod --namespace %s %s` deletes it", pod.Name, pod.Namespace, pod.Name)
+	return exitCode, err
+}
+
+// pickShellPod returns the shell pod called name, the one in namespace if there are several
+func pickShellPod(pods []k8sv1.Pod, name string, namespace string) (k8sv1.Pod, error) {
+	if len(pods) == 0 {
+		return k8sv1.Pod{}, fmt.Errorf("there is no shell pod called %s, `koi shell ls` lists them", name)
+	}
+	if len(pods) == 1 {
+		return pods[0], nil
+	}
+	namespaces := []string{}
+	for _, pod := range pods {
+		if pod.Namespace == namespace {
+			return pod, nil
+		}
+		namespaces = append(namespaces, pod.Namespace)
+	}
+	sort.Strings(namespaces)
+	return k8sv1.Pod{}, fmt.Errorf("there are shell pods called %s in namespaces %s, pick one with --namespace", name, strings.Join(namespaces, ", "))
+}
@@ -0,0 +1,84 @@
+package koi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_pickShellPod(t *testing.T) {
+	newPod := func(namespace string) k8sv1.Pod {
+		return k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1", Namespace: namespace}}
+	}
+
+	tests := []struct {
+		name          string
+		pods          []k8sv1.Pod
+		namespace     string
+		wantNamespace string
+		wantErr       bool
+	}{
+		{
+			name:    "No pods should be an error",
+			wantErr: true,
+		},
+		{
+			name:          "A single pod should be used whatever its namespace",
+			pods:          []k8sv1.Pod{newPod("web")},
+			namespace:     "default",
+			wantNamespace: "web",
+		},
+		{
+			name:          "The pod in the namespace should be picked",
+			pods:          []k8sv1.Pod{newPod("web"), newPod("db")},
+			namespace:     "db",
+			wantNamespace: "db",
+		},
+		{
+			name:      "Several pods outside the namespace should be an error",
+			pods:      []k8sv1.Pod{newPod("web"), newPod("db")},
+			namespace: "default",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickShellPod(tt.pods, "bob-shell-1", tt.namespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pickShellPod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Namespace != tt.wantNamespace {
+				t.Errorf("pickShellPod() namespace got: %v, want: %v", got.Namespace, tt.wantNamespace)
+			}
+		})
+	}
+}
+
+func Test_printShellPods(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pod := k8sv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bob-shell-1",
+			Namespace: "web",
+			Labels:    map[string]string{shellUserLabel: "bob", shellHostLabel: "laptop", shellCreatedAtLabel: "1699996400"},
+		},
+		Status: k8sv1.PodStatus{Phase: k8sv1.PodRunning},
+	}
+
+	var out bytes.Buffer
+	printShellPods(&out, []k8sv1.Pod{pod}, false, now)
+	if got, want := strings.Fields(out.String()), strings.Fields("NAMESPACE NAME USER HOST STATUS AGE web bob-shell-1 bob laptop Running 60m"); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("printShellPods() got: %q, want: %q", got, want)
+	}
+
+	pod.Annotations = map[string]string{contextAnnotation: "prod"}
+	out.Reset()
+	printShellPods(&out, []k8sv1.Pod{pod}, false, now)
+	if got := strings.Fields(out.String()); got[0] != "CONTEXT" || got[7] != "prod" {
+		t.Errorf("printShellPods() with contexts got: %q", got)
+	}
+}
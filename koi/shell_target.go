@@ -0,0 +1,248 @@
+package koi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	k8sv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// defaultContainerAnnotation is how kubectl knows which container of a pod to use when none is given
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// shellInTargetPod adds an ephemeral container running the shell to the target pod and attaches to it
+// The container shares the pod's network and the target container's processes, so it sees what the app sees
+// Clusters which do not allow ephemeral containers get a copy of the pod to debug instead, with kubectlArgs
+func shellInTargetPod(ctx context.Context, shell *ShellInvocation, client *shellClient, kubectlArgs []string) (exitCode int, runError error) {
+	podName, err := targetPodName(shell.target)
+	if err != nil {
+		return 1, err
+	}
+	pod, err := client.getPod(ctx, podName)
+	if err != nil {
+		return 1, err
+	}
+	container, err := targetContainer(*pod, shell.targetContainer)
+	if err != nil {
+		return 1, err
+	}
+
+	if shell.reason != "" {
+		annotated, err := client.annotatePod(ctx, podName, breakGlassAnnotation, shell.reason)
+		if err != nil {
+			log.Warnf("Failed to add the reason to pod %s: %v", podName, err)
+		} else {
+			pod = annotated
+		}
+	}
+
+	log.Debugf("Adding ephemeral container %s to pod %s", shell.name, podName)
+	err = client.addEphemeralContainer(ctx, pod, ephemeralShellContainer(*shell, container))
+	if err != nil && ephemeralContainersUnsupported(err) {
+		log.Warnf("The cluster does not allow ephemeral containers, debugging a copy of pod %s instead: %v", podName, err)
+		return shellInPodCopy(ctx, shell, client, kubectlArgs, podName)
+	}
+	if err != nil {
+		return 1, fmt.Errorf("failed to add an ephemeral container to pod %s: %w", podName, err)
+	}
+
+	log.Info("Waiting for the debug container to start...")
+	err = client.waitForContainer(ctx, podName, shell.name, shell.timeout)
+	if err != nil {
+		return 1, err
+	}
+
+	log.Info("If you don't see a command prompt, try pressing enter.")
+	return client.attach(ctx, podName, shell.name)
+}
+
+// shellInPodCopy uses `kubectl debug --copy-to` to start a copy of the pod with the shell added to it, then attaches to the shell
+// The copy gets the owner labels and TTL of a shell pod, and is deleted once the shell exits, if kubectl got as far as creating it
+func shellInPodCopy(ctx context.Context, shell *ShellInvocation, client *shellClient, kubectlArgs []string, podName string) (exitCode int, runError error) {
+	// A pod with the copy's name which is already there is not koi's to delete
+	_, err := client.getPod(ctx, shell.name)
+	if err == nil {
+		return 1, fmt.Errorf("a pod called %s already exists in namespace %s, pick another --name", shell.name, client.namespace)
+	}
+	if !apierrors.IsNotFound(err) {
+		return 1, err
+	}
+	defer func() {
+		if _, err := client.getPod(context.Background(), shell.name); err != nil {
+			log.Debugf("No copy of the pod to delete: %v", err)
+			return
+		}
+		log.Info("Deleting the copy of the pod...")
+		client.deletePod(shell.name)
+	}()
+
+	// kubectl only creates the copy, koi attaches to it so a signal still gets to the deferred delete
+	debugCommand := withKubectlArgs(kubectlArgs, "debug", "pod/"+podName, "--stdin", "--tty", "--attach=false",
+		"--copy-to="+shell.name, "--image="+shell.image, "--container=shell", "--share-processes", "--")
+	err = runExternalCommand(nil, append(debugCommand, shell.command...)...)
+	if err != nil {
+		return 1, fmt.Errorf("failed to debug a copy of pod %s: %w", podName, err)
+	}
+
+	err = client.markShellPod(ctx, shell.name, *shell)
+	if err != nil {
+		log.Warnf("Failed to label the copy of pod %s, `koi shell gc` will not find it: %v", podName, err)
+	}
+
+	log.Info("Waiting for the copy of the pod to start...")
+	err = client.waitForContainer(ctx, shell.name, "shell", shell.timeout)
+	if err != nil {
+		return 1, err
+	}
+
+	log.Info("If you don't see a command prompt, try pressing enter.")
+	return client.attach(ctx, shell.name, "shell")
+}
+
+// markShellPod gives a pod koi did not create itself the owner labels and TTL of a shell pod, eg: a copy made by kubectl debug
+func (c *shellClient) markShellPod(ctx context.Context, name string, shell ShellInvocation) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": shellOwnerLabels(shell)},
+	}
+	if shell.ttl > 0 {
+		patch["spec"] = map[string]interface{}{"activeDeadlineSeconds": int64(shell.ttl.Seconds())}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal labels: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, shellRequestTimeout)
+	defer cancel()
+	_, err = c.client.CoreV1().Pods(c.namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+	return err
+}
+
+func (c *shellClient) getPod(ctx context.Context, name string) (*k8sv1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, shellRequestTimeout)
+	defer cancel()
+	pod, err := c.client.CoreV1().Pods(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s: %w", name, err)
+	}
+	return pod, nil
+}
+
+// annotatePod sets an annotation on the pod and returns the pod as it is now
+func (c *shellClient) annotatePod(ctx context.Context, name string, key string, value string) (*k8sv1.Pod, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: value},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal annotation: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, shellRequestTimeout)
+	defer cancel()
+	return c.client.CoreV1().Pods(c.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
+
+// addEphemeralContainer adds container to the pod through its ephemeralcontainers subresource
+func (c *shellClient) addEphemeralContainer(ctx context.Context, pod *k8sv1.Pod, container k8sv1.EphemeralContainer) error {
+	pod = pod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, container)
+	ctx, cancel := context.WithTimeout(ctx, shellRequestTimeout)
+	defer cancel()
+	_, err := c.client.CoreV1().Pods(c.namespace).UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{})
+	return err
+}
+
+// ephemeralContainersUnsupported returns true if the API server has no ephemeralcontainers subresource or turns it off
+// Other errors, eg: forbidden by RBAC or denied by an admission webhook, would fail the copy the same way
+func ephemeralContainersUnsupported(err error) bool {
+	return apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) || strings.Contains(strings.ToLower(err.Error()), "not supported")
+}
+
+// waitForContainer watches the pod until the container, which can be an ephemeral container, is running
+func (c *shellClient) waitForContainer(ctx context.Context, podName string, containerName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return c.watchPodUntil(ctx, podName, containerRunning(containerName), func(*k8sv1.Pod) error {
+		return fmt.Errorf("debug container %s did not start within %s", containerName, timeout)
+	})
+}
+
+// containerRunning returns whether the container is running, and an error once it stopped or cannot start
+func containerRunning(containerName string) podReadyFunc {
+	return func(pod *k8sv1.Pod) (bool, error) {
+		statuses := append(append([]k8sv1.ContainerStatus{}, pod.Status.ContainerStatuses...), pod.Status.EphemeralContainerStatuses...)
+		for _, status := range statuses {
+			if status.Name != containerName {
+				continue
+			}
+			if status.State.Running != nil {
+				return true, nil
+			}
+			if terminated := status.State.Terminated; terminated != nil {
+				return false, fmt.Errorf("debug container %s stopped: %s %s", containerName, terminated.Reason, terminated.Message)
+			}
+			if waiting := status.State.Waiting; waiting != nil && stringArrayContains(shellFailureReasons, waiting.Reason) {
+				return false, fmt.Errorf("debug container %s cannot start: %s: %s", containerName, waiting.Reason, waiting.Message)
+			}
+		}
+		return false, nil
+	}
+}
+
+// targetPodName returns the name of the pod from --target, eg: pod/api-1 or api-1
+func targetPodName(target string) (string, error) {
+	kind, name, found := strings.Cut(target, "/")
+	if !found {
+		return target, nil
+	}
+	switch strings.ToLower(kind) {
+	case "pod", "pods", "po":
+		return name, nil
+	}
+	return "", fmt.Errorf("--target must be a pod, eg: pod/api-1, not %q", target)
+}
+
+// targetContainer returns the container the shell shares processes with: the one asked for, or the pod's default container
+func targetContainer(pod k8sv1.Pod, name string) (string, error) {
+	if name == "" {
+		name = pod.Annotations[defaultContainerAnnotation]
+	}
+	if name == "" && len(pod.Spec.Containers) > 0 {
+		name = pod.Spec.Containers[0].Name
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("pod %s has no container %q", pod.GetName(), name)
+}
+
+// ephemeralShellContainer returns the ephemeral container which runs the shell next to the target container
+// The shell is the container's command, so the container stops once the shell exits
+// Ephemeral containers cannot be deleted, stdin is closed when koi detaches, eg: on a signal, so the shell exits then too
+func ephemeralShellContainer(shell ShellInvocation, targetContainer string) k8sv1.EphemeralContainer {
+	return k8sv1.EphemeralContainer{
+		EphemeralContainerCommon: k8sv1.EphemeralContainerCommon{
+			Name:      shell.name,
+			Image:     shell.image,
+			Command:   shell.command,
+			Stdin:     true,
+			StdinOnce: true,
+			TTY:       true,
+		},
+		TargetContainerName: targetContainer,
+	}
+}
+
+// withKubectlArgs returns a new slice of kubectlArgs followed by args, so kubectlArgs is never changed
+func withKubectlArgs(kubectlArgs []string, args ...string) []string {
+	return append(append(make([]string, 0, len(kubectlArgs)+len(args)), kubectlArgs...), args...)
+}
@@ -0,0 +1,286 @@
+package koi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_targetPodName(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "A plain name should be a pod",
+			target: "api-1",
+			want:   "api-1",
+		},
+		{
+			name:   "pod/ should be removed",
+			target: "pod/api-1",
+			want:   "api-1",
+		},
+		{
+			name:   "Kinds should not be case sensitive",
+			target: "Pods/api-1",
+			want:   "api-1",
+		},
+		{
+			name:    "Other kinds should be an error",
+			target:  "deploy/api",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := targetPodName(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("targetPodName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("targetPodName() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_targetContainer(t *testing.T) {
+	pod := k8sv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1"},
+		Spec: k8sv1.PodSpec{
+			Containers: []k8sv1.Container{{Name: "proxy"}, {Name: "api"}},
+		},
+	}
+	withDefault := *pod.DeepCopy()
+	withDefault.Annotations = map[string]string{defaultContainerAnnotation: "api"}
+
+	tests := []struct {
+		name      string
+		pod       k8sv1.Pod
+		container string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name: "The first container should be used by default",
+			pod:  pod,
+			want: "proxy",
+		},
+		{
+			name: "The default container annotation should be used",
+			pod:  withDefault,
+			want: "api",
+		},
+		{
+			name:      "The container asked for should be used",
+			pod:       withDefault,
+			container: "proxy",
+			want:      "proxy",
+		},
+		{
+			name:      "A container which does not exist should be an error",
+			pod:       pod,
+			container: "worker",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := targetContainer(tt.pod, tt.container)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("targetContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("targetContainer() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shellClient_addEphemeralContainer(t *testing.T) {
+	existing := &k8sv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "web"},
+		Spec:       k8sv1.PodSpec{Containers: []k8sv1.Container{{Name: "api"}}},
+	}
+	client := &shellClient{client: fake.NewClientset(existing), namespace: "web"}
+	shell := ShellInvocation{
+		name:    "bob-shell-1",
+		image:   "busybox",
+		command: []string{"sh", "-c", "bash -l || sh -l"},
+	}
+
+	ctx := context.Background()
+	pod, err := client.annotatePod(ctx, "api-1", breakGlassAnnotation, "INC-42")
+	if err != nil {
+		t.Fatalf("annotatePod() error = %v", err)
+	}
+	err = client.addEphemeralContainer(ctx, pod, ephemeralShellContainer(shell, "api"))
+	if err != nil {
+		t.Fatalf("addEphemeralContainer() error = %v", err)
+	}
+
+	got, err := client.getPod(ctx, "api-1")
+	if err != nil {
+		t.Fatalf("getPod() error = %v", err)
+	}
+	if got.Annotations[breakGlassAnnotation] != "INC-42" {
+		t.Errorf("annotatePod() annotations got: %v, want the reason", got.Annotations)
+	}
+	want := []k8sv1.EphemeralContainer{{
+		EphemeralContainerCommon: k8sv1.EphemeralContainerCommon{
+			Name:      "bob-shell-1",
+			Image:     "busybox",
+			Command:   []string{"sh", "-c", "bash -l || sh -l"},
+			Stdin:     true,
+			StdinOnce: true,
+			TTY:       true,
+		},
+		TargetContainerName: "api",
+	}}
+	if !reflect.DeepEqual(got.Spec.EphemeralContainers, want) {
+		t.Errorf("addEphemeralContainer() got: %+v, want: %+v", got.Spec.EphemeralContainers, want)
+	}
+}
+
+func Test_shellClient_waitForContainer(t *testing.T) {
+	waiting := &k8sv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1"},
+		Status: k8sv1.PodStatus{EphemeralContainerStatuses: []k8sv1.ContainerStatus{{
+			Name:  "bob-shell-1",
+			State: k8sv1.ContainerState{Waiting: &k8sv1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+		}}},
+	}
+	running := waiting.DeepCopy()
+	running.Status.EphemeralContainerStatuses[0].State = k8sv1.ContainerState{Running: &k8sv1.ContainerStateRunning{}}
+	pullFailed := waiting.DeepCopy()
+	pullFailed.Status.EphemeralContainerStatuses[0].State.Waiting.Reason = "ImagePullBackOff"
+
+	ctx := context.Background()
+	err := newWatchedShellClient(waiting, running).waitForContainer(ctx, "bob-shell-1", "bob-shell-1", time.Second)
+	if err != nil {
+		t.Errorf("waitForContainer() error = %v", err)
+	}
+
+	err = newWatchedShellClient(waiting, pullFailed).waitForContainer(ctx, "bob-shell-1", "bob-shell-1", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "ImagePullBackOff") {
+		t.Errorf("waitForContainer() should fail when the image cannot be pulled, got: %v", err)
+	}
+
+	err = newWatchedShellClient(waiting).waitForContainer(ctx, "bob-shell-1", "bob-shell-1", 100*time.Millisecond)
+	if err == nil {
+		t.Errorf("waitForContainer() should time out")
+	}
+}
+
+func Test_ephemeralContainersUnsupported(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "A missing subresource should fall back to a copy",
+			err:  apierrors.NewNotFound(pods, "api-1"),
+			want: true,
+		},
+		{
+			name: "A method the API server does not allow should fall back to a copy",
+			err:  apierrors.NewMethodNotSupported(pods, "patch"),
+			want: true,
+		},
+		{
+			name: "An explicit not supported should fall back to a copy",
+			err:  apierrors.NewBadRequest("ephemeral containers are not supported on this cluster"),
+			want: true,
+		},
+		{
+			name: "RBAC forbidding it should not fall back",
+			err:  apierrors.NewForbidden(pods, "api-1", errors.New("cannot patch pods/ephemeralcontainers")),
+		},
+		{
+			name: "An admission denial should not fall back",
+			err:  apierrors.NewForbidden(pods, "api-1", errors.New(`admission webhook "policy" denied the request`)),
+		},
+		{
+			name: "A duplicate container name should not fall back",
+			err: apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "api-1", field.ErrorList{
+				field.Duplicate(field.NewPath("spec", "ephemeralContainers").Index(0).Child("name"), "bob-shell-1"),
+			}),
+		},
+		{
+			name: "Network errors should not fall back",
+			err:  errors.New("dial tcp 10.0.0.1:443: connect: connection refused"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ephemeralContainersUnsupported(tt.err); got != tt.want {
+				t.Errorf("ephemeralContainersUnsupported() got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shellInPodCopy(t *testing.T) {
+	existing := &k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1", Namespace: "web"}}
+	shell := &ShellInvocation{name: "bob-shell-1", image: "busybox", command: []string{"sh"}}
+	// false stands in for a kubectl debug which fails before creating the copy
+	kubectlArgs := []string{"false"}
+
+	clientset := fake.NewClientset(existing)
+	client := &shellClient{client: clientset, namespace: "web"}
+	_, err := shellInPodCopy(context.Background(), shell, client, kubectlArgs, "api-1")
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("shellInPodCopy() should refuse a name which is taken, got: %v", err)
+	}
+	if _, err := client.getPod(context.Background(), "bob-shell-1"); err != nil {
+		t.Errorf("shellInPodCopy() should not delete a pod it did not create: %v", err)
+	}
+
+	clientset = fake.NewClientset()
+	client = &shellClient{client: clientset, namespace: "web"}
+	_, err = shellInPodCopy(context.Background(), shell, client, kubectlArgs, "api-1")
+	if err == nil {
+		t.Errorf("shellInPodCopy() should fail when kubectl debug fails")
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Errorf("shellInPodCopy() should not delete anything when no copy was created, got: %v", action)
+		}
+	}
+}
+
+func Test_shellClient_markShellPod(t *testing.T) {
+	copied := &k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bob-shell-1", Namespace: "web"}}
+	client := &shellClient{client: fake.NewClientset(copied), namespace: "web"}
+	shell := ShellInvocation{user: "bob", host: "laptop", createdAt: time.Unix(1700000000, 0), ttl: time.Hour}
+
+	err := client.markShellPod(context.Background(), "bob-shell-1", shell)
+	if err != nil {
+		t.Fatalf("markShellPod() error = %v", err)
+	}
+	got, err := client.getPod(context.Background(), "bob-shell-1")
+	if err != nil {
+		t.Fatalf("getPod() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.Labels, shellOwnerLabels(shell)) {
+		t.Errorf("markShellPod() labels got: %v, want: %v", got.Labels, shellOwnerLabels(shell))
+	}
+	if got.Spec.ActiveDeadlineSeconds == nil || *got.Spec.ActiveDeadlineSeconds != 3600 {
+		t.Errorf("markShellPod() activeDeadlineSeconds got: %v, want: 3600", got.Spec.ActiveDeadlineSeconds)
+	}
+}
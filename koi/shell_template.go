@@ -0,0 +1,128 @@
+package koi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ShellTemplate is a named kind of shell pod in the config file, eg: one which mounts a database's volume
+// The kubernetes fields are written like they are in a pod manifest
+type ShellTemplate struct {
+	Image          string                                    `yaml:"image,omitempty"`
+	ServiceAccount string                                    `yaml:"serviceAccount,omitempty"`
+	Labels         map[string]string                         `yaml:"labels,omitempty"`
+	Annotations    map[string]string                         `yaml:"annotations,omitempty"`
+	Volumes        manifestField[[]k8sv1.Volume]             `yaml:"volumes,omitempty"`
+	VolumeMounts   manifestField[[]k8sv1.VolumeMount]        `yaml:"volumeMounts,omitempty"`
+	Env            manifestField[[]k8sv1.EnvVar]             `yaml:"env,omitempty"`
+	EnvFrom        manifestField[[]k8sv1.EnvFromSource]      `yaml:"envFrom,omitempty"`
+	Resources      manifestField[k8sv1.ResourceRequirements] `yaml:"resources,omitempty"`
+	// SecurityContext is the shell container's, eg: runAsUser or capabilities
+	SecurityContext manifestField[*k8sv1.SecurityContext] `yaml:"securityContext,omitempty"`
+}
+
+// manifestField holds a kubernetes type in the config file
+// The kubernetes types only have json field names, so the yaml goes through json to keep names like persistentVolumeClaim
+type manifestField[T any] struct {
+	Value T
+}
+
+func (m *manifestField[T]) UnmarshalYAML(node *yaml.Node) error {
+	var raw interface{}
+	err := node.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&m.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	return nil
+}
+
+func (m manifestField[T]) MarshalYAML() (interface{}, error) {
+	content, err := json.Marshal(m.Value)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	err = json.Unmarshal(content, &raw)
+	return raw, err
+}
+
+func (m manifestField[T]) IsZero() bool {
+	return reflect.ValueOf(&m.Value).Elem().IsZero()
+}
+
+// applyShellTemplate adds the template to the shell pod
+func applyShellTemplate(pod *k8sv1.Pod, template ShellTemplate) {
+	for key, value := range template.Labels {
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[key] = value
+	}
+	for key, value := range template.Annotations {
+		// The reason is set by koi
+		if _, ok := pod.Annotations[key]; !ok {
+			pod.Annotations[key] = value
+		}
+	}
+
+	spec := &pod.Spec
+	if template.ServiceAccount != "" {
+		spec.ServiceAccountName = template.ServiceAccount
+	}
+	spec.Volumes = append(spec.Volumes, template.Volumes.Value...)
+
+	container := &spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, template.VolumeMounts.Value...)
+	container.Env = append(container.Env, template.Env.Value...)
+	container.EnvFrom = append(container.EnvFrom, template.EnvFrom.Value...)
+	if !template.Resources.IsZero() {
+		container.Resources = *template.Resources.Value.DeepCopy()
+	}
+	if template.SecurityContext.Value != nil {
+		container.SecurityContext = template.SecurityContext.Value.DeepCopy()
+	}
+}
+
+// parseEnvVars parses KEY=VALUE pairs
+func parseEnvVars(pairs []string) ([]k8sv1.EnvVar, error) {
+	ret := make([]k8sv1.EnvVar, 0, len(pairs))
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("env %q must be KEY=VALUE", pair)
+		}
+		ret = append(ret, k8sv1.EnvVar{Name: name, Value: value})
+	}
+	return ret, nil
+}
+
+// setResources sets the quantities, eg: cpu=100m, on top of the ones already in resources
+func setResources(resources *k8sv1.ResourceList, quantities map[string]string) error {
+	for name, value := range quantities {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("invalid quantity %q for %s: %w", value, name, err)
+		}
+		if *resources == nil {
+			*resources = k8sv1.ResourceList{}
+		}
+		(*resources)[k8sv1.ResourceName(name)] = quantity
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package koi
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const testShellTemplateConfig = `shellTemplates:
+  db-debug:
+    image: postgres:16
+    serviceAccount: db-reader
+    labels:
+      team: payments
+    annotations:
+      admission.stackrox.io/break-glass: from the template
+      owner: dba
+    volumes:
+      - name: data
+        persistentVolumeClaim:
+          claimName: db-data
+    volumeMounts:
+      - name: data
+        mountPath: /data
+        readOnly: true
+    envFrom:
+      - secretRef:
+          name: db-credentials
+    env:
+      - name: PGHOST
+        value: db
+    resources:
+      requests:
+        cpu: 100m
+        memory: 128Mi
+    securityContext:
+      runAsUser: 999
+`
+
+func TestShellTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(testShellTemplateConfig), 0o644)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	template := config.ShellTemplates["db-debug"]
+
+	shell := ShellInvocation{
+		name:       "bob-shell-1",
+		user:       "bob",
+		image:      template.Image,
+		reason:     "INC-42",
+		template:   template,
+		env:        []string{"PGDATABASE=orders"},
+		limits:     map[string]string{"memory": "256Mi"},
+		privileged: true,
+	}
+	pod, err := shellPod(shell)
+	if err != nil {
+		t.Fatalf("shellPod() error = %v", err)
+	}
+	container := pod.Spec.Containers[0]
+
+	if want := map[string]string{"team": "payments", shellLabel: "true", shellUserLabel: "bob", shellHostLabel: ""}; !reflect.DeepEqual(pod.Labels, want) {
+		t.Errorf("shellPod() labels got: %v, want: %v", pod.Labels, want)
+	}
+	if want := map[string]string{breakGlassAnnotation: "INC-42", "owner": "dba"}; !reflect.DeepEqual(pod.Annotations, want) {
+		t.Errorf("shellPod() annotations got: %v, want: %v", pod.Annotations, want)
+	}
+	if pod.Spec.ServiceAccountName != "db-reader" {
+		t.Errorf("shellPod() service account got: %v, want: %v", pod.Spec.ServiceAccountName, "db-reader")
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].PersistentVolumeClaim == nil || pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName != "db-data" {
+		t.Errorf("shellPod() volumes got: %+v, want the db-data claim", pod.Spec.Volumes)
+	}
+	if want := []k8sv1.VolumeMount{{Name: "data", MountPath: "/data", ReadOnly: true}}; !reflect.DeepEqual(container.VolumeMounts, want) {
+		t.Errorf("shellPod() volume mounts got: %+v, want: %+v", container.VolumeMounts, want)
+	}
+	if len(container.EnvFrom) != 1 || container.EnvFrom[0].SecretRef == nil || container.EnvFrom[0].SecretRef.Name != "db-credentials" {
+		t.Errorf("shellPod() envFrom got: %+v, want the db-credentials secret", container.EnvFrom)
+	}
+	if want := []k8sv1.EnvVar{{Name: "PGHOST", Value: "db"}, {Name: "PGDATABASE", Value: "orders"}}; !reflect.DeepEqual(container.Env, want) {
+		t.Errorf("shellPod() env got: %+v, want: %+v", container.Env, want)
+	}
+	wantResources := k8sv1.ResourceRequirements{
+		Requests: k8sv1.ResourceList{k8sv1.ResourceCPU: resource.MustParse("100m"), k8sv1.ResourceMemory: resource.MustParse("128Mi")},
+		Limits:   k8sv1.ResourceList{k8sv1.ResourceMemory: resource.MustParse("256Mi")},
+	}
+	if !container.Resources.Requests.Cpu().Equal(*wantResources.Requests.Cpu()) ||
+		!container.Resources.Requests.Memory().Equal(*wantResources.Requests.Memory()) ||
+		!container.Resources.Limits.Memory().Equal(*wantResources.Limits.Memory()) {
+		t.Errorf("shellPod() resources got: %+v, want: %+v", container.Resources, wantResources)
+	}
+	securityContext := container.SecurityContext
+	if securityContext == nil || securityContext.RunAsUser == nil || *securityContext.RunAsUser != 999 || securityContext.Privileged == nil || !*securityContext.Privileged {
+		t.Errorf("shellPod() security context got: %+v, want runAsUser 999 and privileged", securityContext)
+	}
+	if template.SecurityContext.Value.Privileged != nil {
+		t.Errorf("shellPod() changed the template's security context")
+	}
+
+	// koi config view prints the template with the same names it was written with
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() error = %v", err)
+	}
+	if !strings.Contains(string(out), "persistentVolumeClaim:") || !strings.Contains(string(out), "claimName: db-data") {
+		t.Errorf("yaml.Marshal() got:\n%s\nwant the volume with its manifest field names", out)
+	}
+}
+
+func TestShellTemplate_unknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("shellTemplates:\n  bad:\n    volumes:\n      - name: data\n        pvc:\n          claimName: x\n"), 0o644)
+	_, err := LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "pvc") {
+		t.Errorf("LoadConfig() error got: %v, want an error about the unknown pvc field", err)
+	}
+}
@@ -0,0 +1,113 @@
+package koi
+
+import (
+	"reflect"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func Test_shellPod(t *testing.T) {
+	privileged := true
+	tests := []struct {
+		name    string
+		shell   ShellInvocation
+		want    func(spec *k8sv1.PodSpec)
+		wantErr bool
+	}{
+		{
+			name:  "A plain shell should not have any host access",
+			shell: ShellInvocation{},
+			want:  func(spec *k8sv1.PodSpec) {},
+		},
+		{
+			name:  "--node should pin the pod and tolerate every taint",
+			shell: ShellInvocation{node: "node-1"},
+			want: func(spec *k8sv1.PodSpec) {
+				spec.NodeName = "node-1"
+				spec.Tolerations = []k8sv1.Toleration{{Operator: k8sv1.TolerationOpExists}}
+			},
+		},
+		{
+			name:  "--host-network should keep cluster DNS",
+			shell: ShellInvocation{hostNetwork: true, hostPID: true, privileged: true},
+			want: func(spec *k8sv1.PodSpec) {
+				spec.HostNetwork = true
+				spec.HostPID = true
+				spec.DNSPolicy = k8sv1.DNSClusterFirstWithHostNet
+				spec.Containers[0].SecurityContext = &k8sv1.SecurityContext{Privileged: &privileged}
+			},
+		},
+		{
+			name:  "--node-shell should use the node's namespaces and mount its filesystem",
+			shell: ShellInvocation{node: "node-1", nodeShell: true},
+			want: func(spec *k8sv1.PodSpec) {
+				spec.NodeName = "node-1"
+				spec.HostNetwork = true
+				spec.HostPID = true
+				spec.HostIPC = true
+				spec.DNSPolicy = k8sv1.DNSClusterFirstWithHostNet
+				spec.Containers[0].SecurityContext = &k8sv1.SecurityContext{Privileged: &privileged}
+				spec.Containers[0].VolumeMounts = []k8sv1.VolumeMount{{Name: "host", MountPath: "/host"}}
+				spec.Volumes = []k8sv1.Volume{{Name: "host", VolumeSource: k8sv1.VolumeSource{HostPath: &k8sv1.HostPathVolumeSource{Path: "/"}}}}
+				spec.Tolerations = []k8sv1.Toleration{{Operator: k8sv1.TolerationOpExists}}
+			},
+		},
+		{
+			name:  "Tolerations should be parsed",
+			shell: ShellInvocation{tolerations: []string{"dedicated=gpu:NoSchedule", "spot"}},
+			want: func(spec *k8sv1.PodSpec) {
+				spec.Tolerations = []k8sv1.Toleration{
+					{Key: "dedicated", Operator: k8sv1.TolerationOpEqual, Value: "gpu", Effect: k8sv1.TaintEffectNoSchedule},
+					{Key: "spot", Operator: k8sv1.TolerationOpExists},
+				}
+			},
+		},
+		{
+			name: "--from should start from the workload's pod and keep its host network",
+			shell: ShellInvocation{
+				image: "busybox",
+				fromTemplate: &k8sv1.PodTemplateSpec{Spec: k8sv1.PodSpec{
+					HostNetwork: true,
+					DNSPolicy:   k8sv1.DNSDefault,
+					Containers:  []k8sv1.Container{{Name: "shell", Image: "api:1"}},
+				}},
+			},
+			want: func(spec *k8sv1.PodSpec) {
+				spec.HostNetwork = true
+				spec.DNSPolicy = k8sv1.DNSDefault
+				spec.Containers = []k8sv1.Container{{Name: "shell", Image: "busybox"}}
+			},
+		},
+		{
+			name:    "A toleration with an unknown effect should be an error",
+			shell:   ShellInvocation{tolerations: []string{"dedicated=gpu:NoWay"}},
+			wantErr: true,
+		},
+		{
+			name:    "A toleration without a key should be an error",
+			shell:   ShellInvocation{tolerations: []string{"=gpu"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.shell.name = "bob-shell-1"
+			tt.shell.image = "busybox"
+			got, err := shellPod(tt.shell)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("shellPod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			base, _ := shellPod(ShellInvocation{name: "bob-shell-1", image: "busybox"})
+			want := base.Spec
+			tt.want(&want)
+			if !reflect.DeepEqual(got.Spec, want) {
+				t.Errorf("shellPod() spec got: %+v, want: %+v", got.Spec, want)
+			}
+		})
+	}
+}
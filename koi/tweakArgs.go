@@ -1,7 +1,6 @@
 package koi
 
 import (
-	"os"
 	"strings"
 )
 
@@ -11,28 +10,38 @@ type defaultValueMapping struct {
 	flagsThatMatch []string
 }
 
+// shorthandReplacements maps koi's short flags to the kubectl flags they stand for, -x and the ones from settings
+func shorthandReplacements(settings Settings) map[string]string {
+	ret := map[string]string{
+		"-x": "--context",
+	}
+	for shorthand, longform := range settings.Shorthands {
+		ret[shorthand] = longform
+	}
+	return ret
+}
+
 // applyTweaksToArgs modifies the args sent in so they work with kubectl
 // Goals:
 // The -x flag should become --context
-func ApplyTweaksToArgs(args []string) ([]string, string, string) {
+// The context, namespace and output defaults from settings are added when they are not already set
+func ApplyTweaksToArgs(settings Settings, args []string) ([]string, string, string) {
 	filterExe := ""
 	filterCommand := ""
 
 	replacedFlags := map[string]bool{}
-	shortHandReplacements := map[string]string{
-		"-x": "--context",
-	}
+	shortHandReplacements := shorthandReplacements(settings)
 
 	defaultValuesForFlags := []*defaultValueMapping{
 		{
-			value: coalesceString(os.Getenv("KOI_CONTEXT"), os.Getenv("KOI_CTX")),
+			value: settings.Context,
 			flagsThatMatch: []string{
 				"--context",
 				"-x",
 			},
 		},
 		{
-			value: coalesceString(os.Getenv("KOI_NAMESPACE"), os.Getenv("KOI_NS")),
+			value: settings.Namespace,
 			flagsThatMatch: []string{
 				"--namespace",
 				"-n",
@@ -42,6 +51,7 @@ func ApplyTweaksToArgs(args []string) ([]string, string, string) {
 
 	finalArgs := []string{}
 	endOfKoiArgs := false
+	outputSet := false
 
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
@@ -66,13 +76,15 @@ func ApplyTweaksToArgs(args []string) ([]string, string, string) {
 		}
 
 		// If any of the args match one of the defautl values, then we don't need to apply them
+		flagName, _, _ := splitFlagArg(arg)
 		for _, dv := range defaultValuesForFlags {
-			if !dv.alreadySet && dv.value != "" && stringArrayContains(dv.flagsThatMatch, arg) {
+			if !dv.alreadySet && dv.value != "" && flagMatches(flagName, dv.flagsThatMatch...) {
 				dv.alreadySet = true
 			}
 		}
 
 		if arg == "-o" || arg == "--output" || strings.HasPrefix(arg, "--output=") || strings.HasPrefix(arg, "-o=") {
+			outputSet = true
 			var outputFormat string
 			if strings.Contains(arg, "=") {
 				outputFormat = strings.SplitN(arg, "=", 2)[1]
@@ -118,6 +130,13 @@ func ApplyTweaksToArgs(args []string) ([]string, string, string) {
 			continue
 		}
 
+		// Copy the value of a flag as-is so it is not mistaken for a flag itself
+		if flagConsumesNextArg(arg) && i+1 < len(args) {
+			finalArgs = append(finalArgs, arg, args[i+1])
+			i = i + 1
+			continue
+		}
+
 		finalArgs = append(finalArgs, arg)
 	}
 
@@ -127,6 +146,17 @@ func ApplyTweaksToArgs(args []string) ([]string, string, string) {
 		}
 	}
 
+	// The profile can set a default output per kubectl command, eg: get: wide
+	if outputFormat, ok := settings.Output[GetCommand(finalArgs)]; ok && !outputSet && filterExe == "" {
+		if strings.HasPrefix(outputFormat, "jq") || strings.HasPrefix(outputFormat, "yq") {
+			filterExe = outputFormat[:2]
+			filterCommand = coalesceString(strings.TrimPrefix(outputFormat[2:], "="), ".")
+			finalArgs = appendArgument(finalArgs, "--output=json", "")
+		} else {
+			finalArgs = appendArgument(finalArgs, "--output="+outputFormat, "")
+		}
+	}
+
 	return finalArgs, filterExe, filterCommand
 }
 
@@ -2,6 +2,7 @@ package koi
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -11,6 +12,8 @@ func Test_ApplyTweaksToArgs(t *testing.T) {
 		name              string
 		args              []string
 		env               map[string]string
+		output            map[string]string
+		shorthands        map[string]string
 		want              []string
 		wantFilterExe     string
 		wantFilterCommand string
@@ -67,6 +70,27 @@ func Test_ApplyTweaksToArgs(t *testing.T) {
 				"KOI_CONTEXT": "koi",
 			},
 		},
+		{
+			name: "If KOI_CONTEXT is set, then -x=bob should count as the context being set",
+			args: []string{"get", "pods", "-x=bob"},
+			want: []string{"get", "pods", "--context=bob"},
+			env: map[string]string{
+				"KOI_CONTEXT": "koi",
+			},
+		},
+		{
+			name: "If KOI_NS is set, then -nbob should count as the namespace being set",
+			args: []string{"get", "pods", "-nbob"},
+			want: []string{"get", "pods", "-nbob"},
+			env: map[string]string{
+				"KOI_NS": "koi",
+			},
+		},
+		{
+			name: "Flag values should not be changed",
+			args: []string{"get", "pods", "--as", "-x"},
+			want: []string{"get", "pods", "--as", "-x"},
+		},
 		{
 			name: "If both KOI_CONTEXt and KOI_NS are set, then they should both be used",
 			args: []string{"exec", "-it", "--", "--context", "ignroethis"},
@@ -111,13 +135,53 @@ func Test_ApplyTweaksToArgs(t *testing.T) {
 			wantFilterExe:     "jq",
 			wantFilterCommand: ".",
 		},
+		{
+			name:       "Configured shorthands should be changed to their long flag",
+			args:       []string{"get", "pods", "-N=koi", "-x", "bob"},
+			shorthands: map[string]string{"-N": "--namespace"},
+			want:       []string{"get", "pods", "--namespace=koi", "--context", "bob"},
+		},
+		{
+			name:   "A profile output default should be used for matching commands",
+			args:   []string{"get", "pods"},
+			output: map[string]string{"get": "wide"},
+			want:   []string{"get", "--output=wide", "pods"},
+		},
+		{
+			name:   "A profile output default should not override an explicit output",
+			args:   []string{"get", "pods", "-o", "name"},
+			output: map[string]string{"get": "wide"},
+			want:   []string{"get", "pods", "--output=name"},
+		},
+		{
+			name:              "A profile output default can be a filter",
+			args:              []string{"get", "pods"},
+			output:            map[string]string{"get": "yq"},
+			want:              []string{"get", "--output=json", "pods"},
+			wantFilterExe:     "yq",
+			wantFilterCommand: ".",
+		},
+		{
+			name:   "A profile output default should not be used for other commands",
+			args:   []string{"describe", "pods"},
+			output: map[string]string{"get": "wide"},
+			want:   []string{"describe", "pods"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("KOI_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+			os.Setenv("XDG_STATE_HOME", t.TempDir())
 			for key, val := range tt.env {
 				os.Setenv(key, val)
 			}
-			got, gotFilterExe, gotFilterCommand := ApplyTweaksToArgs(tt.args)
+			settings, err := LoadSettings()
+			if err != nil {
+				t.Fatalf("LoadSettings() error = %v", err)
+			}
+			settings.Output = tt.output
+			settings.Shorthands = tt.shorthands
+			got, gotFilterExe, gotFilterCommand := ApplyTweaksToArgs(settings, tt.args)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("applyTweaksToArgs() got: %v, want: %v", got, tt.want)
 			}
@@ -130,6 +194,8 @@ func Test_ApplyTweaksToArgs(t *testing.T) {
 			for key := range tt.env {
 				os.Unsetenv(key)
 			}
+			os.Unsetenv("KOI_CONFIG")
+			os.Unsetenv("XDG_STATE_HOME")
 		})
 	}
 }
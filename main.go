@@ -2,129 +2,120 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
 
 	"github.com/oliverisaac/koi/koi"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 )
 
 var version string = "unset-version"
 var commit string = "unset-commit"
 
+func init() {
+	koi.RegisterCommand(&koi.Command{
+		Name:             "version",
+		Summary:          "Print the koi version, then the kubectl version",
+		Usage:            "koi version [kubectl version flags]",
+		PassUnknownFlags: true,
+		Setup: func(f *pflag.FlagSet, settings koi.Settings) koi.RunFunc {
+			return func(inv koi.Invocation) (int, error) {
+				fmt.Printf("Koi version: %s (%s)\n", version, commit)
+				return koi.RunKubectl(inv, inv.KubectlArgs)
+			}
+		},
+	})
+}
+
 func main() {
 	var exitCode int
 	var err error
 
-	logLevel := defaultEnv("KOI_LOG_LEVEL", "INFO")
-	if ll, err := logrus.ParseLevel(logLevel); err == nil {
-		logrus.SetLevel(ll)
+	settings, err := koi.LoadSettings()
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "Failed to load koi config"))
 	}
 
-	exe := defaultEnv("KOI_KUBECTL_EXE", "kubectl")
-	koiArgs, filterExe, filterCommand := koi.ApplyTweaksToArgs(os.Args[1:])
-
-	baseCommand := os.Args[0]
-	requestedKoiCommand := koi.GetCommand(koiArgs)
-	logrus.Debugf("Requested command: %s", requestedKoiCommand)
-	if requestedKoiCommand == "events" {
-		exitCode, err = koi.EventsCommand(exe, koiArgs)
-	} else if requestedKoiCommand == "fish" {
-		exitCode, err = koi.FishCommand(exe, koiArgs)
-	} else if requestedKoiCommand == "version" {
-		fmt.Printf("Koi version: %s (%s)\n", version, commit)
-		exitCode, err = runAttachedCommand(exe, filterExe, filterCommand, koiArgs)
-	} else if requestedKoiCommand == "export" {
-		exitCode, err = koi.ExportCommand(os.Stdin, os.Stdout)
-	} else if requestedKoiCommand == "shell" || baseCommand == "kshell" {
-		koiArgs = removeArg(koiArgs, "shell")
-		exitCode, err = koi.ShellCommand(exe, koiArgs)
-	} else if requestedKoiCommand == "containers" || baseCommand == "kcontainers" {
-		koiArgs = removeArg(koiArgs, "containers")
-		exitCode, err = koi.ContainersCommand(koiArgs)
-	} else {
-		exitCode, err = runAttachedCommand(exe, filterExe, filterCommand, koiArgs)
+	if ll, err := logrus.ParseLevel(settings.LogLevel); err == nil {
+		logrus.SetLevel(ll)
 	}
 
+	args, err := koi.ExpandAliases(settings, os.Args[1:])
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "Failed to run the command"))
-		os.Exit(1)
+		log.Fatal(err)
 	}
-	os.Exit(exitCode)
-}
-
-func removeArg(args []string, arg string) []string {
-	for i, a := range args {
-		if a == "--" {
-			return args
-		}
-		if a == arg {
-			return append(args[:i], args[i+1:]...)
+	args, yes := koi.ExtractYesFlag(args)
+	koiArgs, filterExe, filterCommand := koi.ApplyTweaksToArgs(settings, args)
+
+	command, commandArgs := koi.LookupCommand(filepath.Base(os.Args[0]), koiArgs)
+
+	// Compile the filter up front so a typo fails before kubectl is run
+	// Completion never filters, eg: completing `koi get pods --jq '.items[`
+	var filter *koi.OutputFilter
+	if command == nil || !command.SkipFilter {
+		filter, err = koi.NewOutputFilter(filterExe, filterCommand, settings.FilterMode == "external")
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
-	return args
-}
 
-func defaultEnv(env string, defaultVal string) string {
-	if val, ok := os.LookupEnv(env); ok {
-		return val
+	inv := koi.Invocation{
+		Settings:    settings,
+		RawArgs:     os.Args[1:],
+		KubectlArgs: koiArgs,
+		Filter:      filter,
 	}
-	return defaultVal
-}
 
-func runAttachedCommand(command string, filterExe string, filterCommand string, args []string) (exitCode int, runErr error) {
-	cmd := exec.Command(command, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	var yqCmd *exec.Cmd
-	var cmdOut *io.PipeWriter
-	if filterExe != "" {
-		var yqIn *io.PipeReader
-		yqIn, cmdOut = io.Pipe()
-		filterArgs := []string{}
-		if filterExe == "yq" {
-			filterArgs = append(filterArgs, "-P")
-		} else if filterExe == "jq" {
-			filterArgs = append(filterArgs, "-r")
+	// --contexts runs a kubectl command in several contexts at once
+	contexts := []string{}
+	if command == nil {
+		var contextPatterns string
+		commandArgs, contextPatterns = koi.ExtractContextsFlag(commandArgs)
+		if contextPatterns != "" {
+			contexts, err = koi.ResolveContexts(contextPatterns, commandArgs)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
-		filterArgs = append(filterArgs, filterCommand)
-		yqCmd = exec.Command(filterExe, filterArgs...)
-
-		yqCmd.Stdin = yqIn
-		yqCmd.Stdout = os.Stdout
-		yqCmd.Stderr = os.Stderr
+	}
 
-		cmd.Stdout = cmdOut
-		cmd.Stderr = os.Stderr
+	commandName := ""
+	if command != nil {
+		commandName = command.Name
+	}
+	if command == nil || !command.SkipAudit {
+		inv.Audit = koi.NewAuditRecord(settings, commandName, commandArgs)
 	}
 
-	if yqCmd != nil {
-		yqErr := yqCmd.Start()
-		if yqErr != nil {
-			return 1, errors.Wrapf(yqErr, "Failed to start yq command")
-		}
+	if len(contexts) > 0 {
+		err = koi.CheckGuardrailsInContexts(settings, commandArgs, contexts, yes)
+	} else {
+		err = koi.CheckGuardrails(settings, commandName, commandArgs, yes)
+	}
+	if err != nil {
+		inv.Audit.Finish(1, err)
+		koi.WriteAuditRecord(settings.Audit, inv.Audit)
+		log.Fatal(err)
 	}
 
-	cmdErr := cmd.Start()
-	if cmdErr != nil {
-		return 1, errors.Wrapf(cmdErr, "Failed to start command %q %q", command, args)
+	if command != nil {
+		logrus.Debugf("Requested command: %s", command.Name)
+		exitCode, err = command.Run(inv, commandArgs)
+	} else if len(contexts) > 0 {
+		exitCode, err = koi.RunKubectlInContexts(inv, contexts, commandArgs)
+	} else {
+		exitCode, err = koi.RunKubectl(inv, commandArgs)
 	}
 
-	ps, cmdErr := cmd.Process.Wait()
+	inv.Audit.Finish(exitCode, err)
+	koi.WriteAuditRecord(settings.Audit, inv.Audit)
 
-	if yqCmd != nil {
-		cmdOut.Close()
-		_, yqErr := yqCmd.Process.Wait()
-		if yqErr != nil {
-			return 1, errors.Wrapf(yqErr, "Failed to run yq command")
-		}
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "Failed to run the command"))
+		os.Exit(1)
 	}
-
-	exitCode = ps.ExitCode()
-	return exitCode, errors.Wrapf(cmdErr, "Failed to run command %q %q", command, args)
+	os.Exit(exitCode)
 }